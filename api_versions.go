@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+const apiKeyOffsetFetch int16 = 9
+
+// offsetFetchVersions lists the OffsetFetch versions sarama can encode, from
+// the newest to the oldest, with the Kafka version sarama requires to be
+// configured to send them.
+var offsetFetchVersions = []struct {
+	version      int16
+	kafkaVersion sarama.KafkaVersion
+}{
+	{7, sarama.V2_5_0_0},
+	{6, sarama.V2_4_0_0},
+	{5, sarama.V2_1_0_0},
+	{4, sarama.V2_0_0_0},
+	{3, sarama.V0_11_0_0},
+	{2, sarama.V0_10_2_0},
+	{1, sarama.V0_8_2_0},
+}
+
+// apiVersionCache holds the highest version of each API supported by the
+// brokers, as reported by ApiVersions.
+type apiVersionCache struct {
+	mu       sync.Mutex
+	versions map[int32]map[int16]int16
+}
+
+func newAPIVersionCache() *apiVersionCache {
+	return &apiVersionCache{versions: make(map[int32]map[int16]int16)}
+}
+
+// maxAPIVersions returns the highest version of each API in the response.
+func maxAPIVersions(response *sarama.ApiVersionsResponse) map[int16]int16 {
+	versions := make(map[int16]int16, len(response.ApiVersions))
+	for _, block := range response.ApiVersions {
+		versions[block.ApiKey] = block.MaxVersion
+	}
+	return versions
+}
+
+func (c *apiVersionCache) set(brokerID int32, response *sarama.ApiVersionsResponse) {
+	versions := maxAPIVersions(response)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions[brokerID] = versions
+}
+
+func (c *apiVersionCache) get(brokerID int32) (map[int16]int16, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versions, ok := c.versions[brokerID]
+	return versions, ok
+}
+
+// apiVersion returns the highest version of an API supported by the broker.
+// The versions are refreshed by the broker checks of every scrape and
+// requested from the broker when it has not been checked yet. It returns
+// false when the broker is older than 0.10 and does not support ApiVersions.
+func (e *Exporter) apiVersion(broker *sarama.Broker, apiKey int16) (int16, bool) {
+	if !e.client.Config().Version.IsAtLeast(sarama.V0_10_0_0) {
+		return 0, false
+	}
+	versions, ok := e.apiVersions.get(broker.ID())
+	if !ok {
+		response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+		e.stats.request("ApiVersions", broker, err)
+		if err != nil || response.Err != sarama.ErrNoError {
+			return 0, false
+		}
+		e.apiVersions.set(broker.ID(), response)
+		versions, _ = e.apiVersions.get(broker.ID())
+	}
+	version, ok := versions[apiKey]
+	return version, ok
+}
+
+// offsetFetchVersion returns the OffsetFetch version to send to the broker,
+// the highest one supported by both the broker and sarama with the
+// configured Kafka version.
+func (e *Exporter) offsetFetchVersion(broker *sarama.Broker) int16 {
+	supported, ok := e.apiVersion(broker, apiKeyOffsetFetch)
+	if !ok {
+		// Supported by every broker since 0.8.2.
+		supported = 1
+	}
+	kafkaVersion := e.client.Config().Version
+	for _, v := range offsetFetchVersions {
+		if v.version <= supported && kafkaVersion.IsAtLeast(v.kafkaVersion) {
+			return v.version
+		}
+	}
+	return 1
+}
+
+// supportsOffsetFetchGroups tells whether the offsets of several groups can
+// be fetched at once from the broker with OffsetFetch v8, which is sent
+// without sarama and so regardless of the configured Kafka version.
+func (e *Exporter) supportsOffsetFetchGroups(broker *sarama.Broker) bool {
+	supported, ok := e.apiVersion(broker, apiKeyOffsetFetch)
+	return ok && supported >= offsetFetchGroupsVersion && offsetFetchGroupsSupported(e.client.Config())
+}
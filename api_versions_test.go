@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestOffsetFetchVersion(t *testing.T) {
+	for _, test := range []struct {
+		kafkaVersion sarama.KafkaVersion
+		maxVersion   int16
+		expected     int16
+		groups       bool
+	}{
+		{sarama.V2_0_0_0, 3, 3, false},
+		{sarama.V2_8_0_0, 8, 7, true},
+		// sarama does not send newer versions than the configured Kafka
+		// version, unlike the requests for several groups.
+		{sarama.V2_0_0_0, 8, 4, true},
+		{sarama.V0_9_0_0, 8, 1, false},
+	} {
+		broker := sarama.NewMockBroker(t, 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()),
+			"ApiVersionsRequest": sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+				ApiVersions: []*sarama.ApiVersionsResponseBlock{
+					{ApiKey: apiKeyOffsetFetch, MaxVersion: test.maxVersion},
+				},
+			}),
+		})
+
+		config := sarama.NewConfig()
+		config.Version = test.kafkaVersion
+		client, err := sarama.NewClient([]string{broker.Addr()}, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		coordinator, err := client.Broker(broker.BrokerID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := coordinator.Open(config); err != nil && err != sarama.ErrAlreadyConnected {
+			t.Fatal(err)
+		}
+
+		e := &Exporter{client: client, apiVersions: newAPIVersionCache()}
+		if version := e.offsetFetchVersion(coordinator); version != test.expected {
+			t.Errorf("Kafka %s supporting OffsetFetch v%d: expected v%d, got v%d", test.kafkaVersion, test.maxVersion, test.expected, version)
+		}
+		if groups := e.supportsOffsetFetchGroups(coordinator); groups != test.groups {
+			t.Errorf("Kafka %s supporting OffsetFetch v%d: expected %t for several groups, got %t", test.kafkaVersion, test.maxVersion, test.groups, groups)
+		}
+		client.Close()
+		broker.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectBrokerMetrics exports the identity, role and reachability of every
+// broker of the cluster, along with the number of partitions they lead and
+// replicate among the topics matching the topic filter. The Kafka version of
+// the reachable brokers is derived from their ApiVersions response.
+func (e *Exporter) collectBrokerMetrics(ch chan<- prometheus.Metric, leaderPartitions map[int32]int, replicaPartitions map[int32]int) {
+	controllerID := int32(-1)
+	if controller, err := e.client.Controller(); err != nil {
+		e.scrapeErrorf(phaseBrokers, "Cannot get controller: %v", err)
+	} else {
+		controllerID = controller.ID()
+	}
+
+	var wg sync.WaitGroup
+	for _, broker := range e.client.Brokers() {
+		id := strconv.Itoa(int(broker.ID()))
+		ch <- prometheus.MustNewConstMetric(
+			brokerInfo, prometheus.GaugeValue, 1, id, broker.Addr(), broker.Rack(),
+		)
+
+		isController := 0
+		if broker.ID() == controllerID {
+			isController = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			brokerIsController, prometheus.GaugeValue, float64(isController), id,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			brokerLeaderPartitions, prometheus.GaugeValue, float64(leaderPartitions[broker.ID()]), id,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			brokerReplicaPartitions, prometheus.GaugeValue, float64(replicaPartitions[broker.ID()]), id,
+		)
+
+		wg.Add(1)
+		go func(broker *sarama.Broker, id string) {
+			defer wg.Done()
+			up := 0
+			if err := e.checkBroker(broker); err != nil {
+				e.scrapeErrorf(phaseBrokers, "Broker %d is not reachable: %v", broker.ID(), err)
+			} else {
+				up = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				brokerUp, prometheus.GaugeValue, float64(up), id,
+			)
+			if versions, ok := e.apiVersions.get(broker.ID()); ok && up == 1 {
+				ch <- prometheus.MustNewConstMetric(
+					brokerAPIVersionInfo, prometheus.GaugeValue, 1, id, kafkaVersionOf(versions).String(),
+				)
+			}
+		}(broker, id)
+	}
+	wg.Wait()
+}
+
+// checkBroker opens a connection to the broker, if needed, and makes sure it
+// answers an ApiVersions request.
+func (e *Exporter) checkBroker(broker *sarama.Broker) error {
+	if err := broker.Open(e.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+		return err
+	}
+	if !e.client.Config().Version.IsAtLeast(sarama.V0_10_0_0) {
+		// ApiVersions is not supported, the connection is all we can check.
+		connected, err := broker.Connected()
+		if err == nil && !connected {
+			err = sarama.ErrNotConnected
+		}
+		return err
+	}
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	e.stats.request("ApiVersions", broker, err)
+	if err != nil {
+		// The client reopens the connection the next time it uses the broker.
+		_ = broker.Close()
+		return err
+	}
+	if response.Err != sarama.ErrNoError {
+		return response.Err
+	}
+	e.apiVersions.set(broker.ID(), response)
+	return nil
+}
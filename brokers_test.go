@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectBrokerMetrics(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	descs := exporterTestDescs()
+	e := newTestExporter(t, first, kafkaOpts{})
+	defer e.Close()
+	// Broker 2 stays in the metadata but is no longer reachable.
+	second.Close()
+
+	metrics := collectTestMetrics(t, descs, func(ch chan<- prometheus.Metric) {
+		e.collectBrokerMetrics(ch, map[int32]int{1: 2, 2: 1}, map[int32]int{1: 3, 2: 3})
+	})
+
+	for key, expected := range map[string]float64{
+		`kafka_broker_info{address="` + first.Addr() + `",id="1",rack=""}`:  1,
+		`kafka_broker_info{address="` + second.Addr() + `",id="2",rack=""}`: 1,
+		`kafka_broker_is_controller{id="1"}`:                                1,
+		`kafka_broker_is_controller{id="2"}`:                                0,
+		`kafka_broker_up{id="1"}`:                                           1,
+		`kafka_broker_up{id="2"}`:                                           0,
+		`kafka_broker_api_version_info{id="1",version="2.0.0"}`:             1,
+		`kafka_broker_leader_partitions{id="1"}`:                            2,
+		`kafka_broker_leader_partitions{id="2"}`:                            1,
+		`kafka_broker_replica_partitions{id="1"}`:                           3,
+		`kafka_broker_replica_partitions{id="2"}`:                           3,
+	} {
+		if value, ok := metrics[key]; !ok || value != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", key, expected, value, ok)
+		}
+	}
+	if len(metrics) != 11 {
+		t.Errorf("expected no version of the unreachable broker, got %v", metrics)
+	}
+
+	if errors := e.stats.errors[phaseBrokers]; errors != 1 {
+		t.Errorf("expected the unreachable broker to be a scrape error, got %v", errors)
+	}
+	for broker, expected := range map[int32]requestCount{1: {total: 1}, 2: {total: 1, errors: 1}} {
+		if count := e.stats.requests[requestKey{api: "ApiVersions", broker: broker}]; count == nil || *count != expected {
+			t.Errorf("broker %d: expected the ApiVersions requests %+v, got %+v", broker, expected, count)
+		}
+	}
+}
+
+func TestCheckBrokerBeforeApiVersions(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	defer second.Close()
+	e := newTestExporter(t, first, kafkaOpts{})
+	defer e.Close()
+	// Brokers older than 0.10 only have their connection checked.
+	e.client.Config().Version = sarama.V0_9_0_0
+
+	broker, err := e.client.Broker(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.checkBroker(broker); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.stats.requests) != 0 {
+		t.Errorf("expected no ApiVersions request, got %v", e.stats.requests)
+	}
+	if _, ok := e.apiVersions.get(2); ok {
+		t.Error("expected no API versions of the broker")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rcrowley/go-metrics"
+)
+
+// The Kafka client registers its per broker metrics with this suffix, along
+// with their sum over all brokers under the bare name, which is not exported.
+const clientMetricBrokerSuffix = "-for-broker-"
+
+// clientMetricQuantiles are the quantiles exported for the client histograms,
+// computed over a sample of the recent requests.
+var clientMetricQuantiles = []float64{0.5, 0.9, 0.99}
+
+// collectClientMetrics exports the per broker metrics of the Kafka client,
+// kept by sarama in its go-metrics registry.
+func (e *Exporter) collectClientMetrics(ch chan<- prometheus.Metric) {
+	e.client.Config().MetricRegistry.Each(func(name string, metric interface{}) {
+		i := strings.LastIndex(name, clientMetricBrokerSuffix)
+		if i < 0 {
+			return
+		}
+		broker := name[i+len(clientMetricBrokerSuffix):]
+		switch name[:i] {
+		case "incoming-byte-rate":
+			collectClientMeter(ch, clientIncomingBytes, metric, broker)
+		case "outgoing-byte-rate":
+			collectClientMeter(ch, clientOutgoingBytes, metric, broker)
+		case "request-rate":
+			collectClientMeter(ch, clientRequests, metric, broker)
+		case "response-rate":
+			collectClientMeter(ch, clientResponses, metric, broker)
+		case "request-size":
+			collectClientHistogram(ch, clientRequestSize, metric, broker, 1)
+		case "response-size":
+			collectClientHistogram(ch, clientResponseSize, metric, broker, 1)
+		case "request-latency-in-ms":
+			collectClientHistogram(ch, clientRequestLatency, metric, broker, 1e-3)
+		case "requests-in-flight":
+			if counter, ok := metric.(metrics.Counter); ok {
+				ch <- prometheus.MustNewConstMetric(
+					clientRequestsInFlight, prometheus.GaugeValue, float64(counter.Count()), broker,
+				)
+			}
+		}
+	})
+}
+
+func collectClientMeter(ch chan<- prometheus.Metric, desc *prometheus.Desc, metric interface{}, broker string) {
+	if meter, ok := metric.(metrics.Meter); ok {
+		ch <- prometheus.MustNewConstMetric(
+			desc, prometheus.CounterValue, float64(meter.Count()), broker,
+		)
+	}
+}
+
+func collectClientHistogram(ch chan<- prometheus.Metric, desc *prometheus.Desc, metric interface{}, broker string, scale float64) {
+	histogram, ok := metric.(metrics.Histogram)
+	if !ok || histogram.Count() == 0 {
+		return
+	}
+	values := histogram.Snapshot().Percentiles(clientMetricQuantiles)
+	for i, quantile := range clientMetricQuantiles {
+		ch <- prometheus.MustNewConstMetric(
+			desc, prometheus.GaugeValue, values[i]*scale, broker, strconv.FormatFloat(quantile, 'g', -1, 64),
+		)
+	}
+}
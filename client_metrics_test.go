@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/rcrowley/go-metrics"
+)
+
+// configClient is a Kafka client that only has a config.
+type configClient struct {
+	sarama.Client
+	config *sarama.Config
+}
+
+func (c configClient) Config() *sarama.Config { return c.config }
+
+func TestCollectClientMetrics(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	defer second.Close()
+	descs := exporterTestDescs()
+	e := newTestExporter(t, first, kafkaOpts{})
+	defer e.Close()
+
+	metrics := collectTestMetrics(t, descs, e.Collect)
+
+	for _, name := range []string{
+		"kafka_exporter_client_incoming_bytes_total",
+		"kafka_exporter_client_outgoing_bytes_total",
+		"kafka_exporter_client_requests_total",
+		"kafka_exporter_client_responses_total",
+	} {
+		if value := metrics[name+`{broker="1"}`]; value <= 0 {
+			t.Errorf("%s: expected the traffic with broker 1, got %v", name, value)
+		}
+	}
+	if value, ok := metrics[`kafka_exporter_client_requests_in_flight{broker="1"}`]; !ok || value != 0 {
+		t.Errorf("expected no request in flight after the scrape, got %v (%t)", value, ok)
+	}
+	for _, quantile := range []string{"0.5", "0.9", "0.99"} {
+		for _, name := range []string{
+			"kafka_exporter_client_request_latency_seconds",
+			"kafka_exporter_client_request_size_bytes",
+			"kafka_exporter_client_response_size_bytes",
+		} {
+			if _, ok := metrics[name+`{broker="1",quantile="`+quantile+`"}`]; !ok {
+				t.Errorf("%s: expected the quantile %s for broker 1", name, quantile)
+			}
+		}
+	}
+}
+
+func TestCollectClientMetricsRegistry(t *testing.T) {
+	descs := exporterTestDescs()
+	registry := metrics.NewRegistry()
+	// The sums over all brokers are not exported.
+	metrics.GetOrRegisterMeter("request-rate", registry).Mark(5)
+	metrics.GetOrRegisterMeter("request-rate-for-broker-3", registry).Mark(5)
+	metrics.GetOrRegisterCounter("requests-in-flight-for-broker-3", registry).Inc(2)
+	latency := metrics.GetOrRegisterHistogram("request-latency-in-ms-for-broker-3", registry, metrics.NewUniformSample(10))
+	latency.Update(250)
+	// No quantiles are exported before the first response.
+	metrics.GetOrRegisterHistogram("response-size-for-broker-3", registry, metrics.NewUniformSample(10))
+	config := sarama.NewConfig()
+	config.MetricRegistry = registry
+	e := &Exporter{client: configClient{config: config}}
+
+	values := collectTestMetrics(t, descs, e.collectClientMetrics)
+
+	expected := map[string]float64{
+		`kafka_exporter_client_requests_total{broker="3"}`:                          5,
+		`kafka_exporter_client_requests_in_flight{broker="3"}`:                      2,
+		`kafka_exporter_client_request_latency_seconds{broker="3",quantile="0.5"}`:  0.25,
+		`kafka_exporter_client_request_latency_seconds{broker="3",quantile="0.9"}`:  0.25,
+		`kafka_exporter_client_request_latency_seconds{broker="3",quantile="0.99"}`: 0.25,
+	}
+	for key, value := range expected {
+		if values[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, values[key])
+		}
+	}
+	if len(values) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), values)
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// clusterConnectBackoff is the delay before connecting again to a
+	// cluster that cannot be reached, doubled after every failure up to
+	// clusterConnectMaxBackoff.
+	clusterConnectBackoff    = time.Second
+	clusterConnectMaxBackoff = 2 * time.Minute
+
+	// reloadConnectTimeout is how long a reload waits for the clusters of
+	// the new configuration to connect before swapping it in.
+	reloadConnectTimeout = 10 * time.Second
+)
+
+// clusterCollector collects the metrics of one of the clusters listed in the
+// config file. The Exporter is created in the background and recreated with
+// a backoff until it succeeds, so that an unreachable cluster does not
+// prevent the exporter from starting, or slow down the scrapes of the other
+// clusters. Until then only kafka_exporter_cluster_up is exported.
+type clusterCollector struct {
+	name        string
+	opts        kafkaOpts
+	topicFilter string
+	groupFilter string
+	quit        chan struct{}
+	quitOnce    sync.Once
+	// Closed after the first connection attempt.
+	attempted chan struct{}
+
+	// Held while connecting, so that concurrent scrapes connect once.
+	connectMu sync.Mutex
+	// The collector of the same cluster in the configuration being
+	// replaced, whose state the Exporter takes over once connected, and the
+	// lock of the scrapes of its set. Guarded by connectMu.
+	previous        *clusterCollector
+	previousScrapes sync.Locker
+
+	mu         sync.Mutex
+	exporter   *Exporter
+	connectErr error
+	closed     bool
+	// Whether the Exporter was handed over to the collector replacing this
+	// one, which closes it.
+	handedOver bool
+	// The Exporter closed with the collector, whose state a collector
+	// replacing this one still takes over.
+	last *Exporter
+}
+
+func newClusterCollector(cfg clusterConfig, flags kafkaOpts) *clusterCollector {
+	return &clusterCollector{
+		name:        cfg.Name,
+		opts:        cfg.kafkaOpts(flags),
+		topicFilter: cfg.TopicFilter,
+		groupFilter: cfg.GroupFilter,
+		quit:        make(chan struct{}),
+		attempted:   make(chan struct{}),
+	}
+}
+
+// start connects to the cluster in the background.
+func (c *clusterCollector) start() {
+	go c.connectLoop()
+}
+
+func (c *clusterCollector) connectLoop() {
+	backoff := clusterConnectBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := c.getExporter()
+		if attempt == 0 {
+			close(c.attempted)
+		}
+		if err == nil || c.isClosed() {
+			return
+		}
+		glog.Errorf("Cannot connect to cluster %s, retrying in %v: %v", c.name, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-c.quit:
+			return
+		}
+		if backoff *= 2; backoff > clusterConnectMaxBackoff {
+			backoff = clusterConnectMaxBackoff
+		}
+	}
+}
+
+// getExporter returns the Exporter of the cluster, connecting to it if needed.
+func (c *clusterCollector) getExporter() (*Exporter, error) {
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	if exporter := c.current(); exporter != nil {
+		return exporter, nil
+	}
+	if c.isClosed() {
+		return nil, fmt.Errorf("cluster %s is closed", c.name)
+	}
+	exporter, err := newExporter(c.opts, c.topicFilter, c.groupFilter)
+	if err == nil {
+		c.takeOver(exporter)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handedOver {
+		// Replaced in the meantime.
+		if exporter != nil {
+			exporter.Close()
+		}
+		return c.exporter, nil
+	}
+	c.exporter, c.connectErr = exporter, err
+	return exporter, err
+}
+
+// takeOver starts exporter, which takes over the state of the collector this
+// one replaces, if any. The caller holds connectMu.
+func (c *clusterCollector) takeOver(exporter *Exporter) {
+	if c.previous == nil {
+		exporter.start()
+		return
+	}
+	c.previous.handOver(exporter, c.previousScrapes)
+	c.previous, c.previousScrapes = nil, nil
+}
+
+// handOver stops the Exporter of the collector, which is replaced by one
+// built from a new configuration, and passes its state to exporter, which is
+// started and exported in its place until the collector is closed, so that
+// the cluster keeps being exported until the new configuration is swapped
+// in. The Exporters are swapped with scrapes locked, so that the scrapes in
+// progress complete first.
+func (c *clusterCollector) handOver(exporter *Exporter, scrapes sync.Locker) {
+	scrapes.Lock()
+	defer scrapes.Unlock()
+	c.quitOnce.Do(func() { close(c.quit) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.last
+	if !c.closed {
+		previous = c.exporter
+		if previous != nil {
+			if err := previous.Close(); err != nil {
+				glog.Errorf("Cannot close Kafka client of cluster %s: %v", c.name, err)
+			}
+		}
+		c.exporter, c.connectErr, c.handedOver = exporter, nil, true
+	}
+	if previous != nil {
+		exporter.inherit(previous)
+	}
+	exporter.start()
+}
+
+// reconnect replaces the Exporter of the cluster by one with a new Kafka
+// client, which reads the credentials again, and which takes over the state
+// of the current one. The Exporters are swapped with scrapes locked, so that
+// the scrapes in progress complete first. A cluster that is not connected
+// yet reads the credentials again on its next attempt.
+func (c *clusterCollector) reconnect(scrapes sync.Locker) error {
+	previous := c.current()
+	if previous == nil || c.isHandedOver() {
+		return nil
+	}
+	exporter, err := newExporter(c.opts, c.topicFilter, c.groupFilter)
+	if err != nil {
+		return err
+	}
+
+	scrapes.Lock()
+	defer scrapes.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.exporter != previous {
+		// Closed in the meantime.
+		return exporter.Close()
+	}
+	if err := previous.Close(); err != nil {
+		glog.Errorf("Cannot close Kafka client of cluster %s: %v", c.name, err)
+	}
+	exporter.inherit(previous)
+	exporter.start()
+	c.exporter = exporter
+	return nil
+}
+
+// state returns the Exporter of the cluster, or nil with the error of the
+// last connection attempt when it is not connected, without waiting for a
+// connection in progress.
+func (c *clusterCollector) state() (*Exporter, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exporter, c.connectErr
+}
+
+// current returns the Exporter of the cluster without waiting for a
+// connection in progress, or nil when it is not connected.
+func (c *clusterCollector) current() *Exporter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.exporter
+}
+
+func (c *clusterCollector) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *clusterCollector) isHandedOver() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handedOver
+}
+
+// Describe implements prometheus.Collector.
+func (c *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- exporterClusterUp
+	(&Exporter{}).Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	exporter := c.current()
+	up := 0.0
+	if exporter != nil {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(exporterClusterUp, prometheus.GaugeValue, up)
+	if exporter != nil {
+		exporter.Collect(ch)
+	}
+}
+
+// Close stops connecting to the cluster and releases its Kafka client, if
+// any and not handed over.
+func (c *clusterCollector) Close() error {
+	c.quitOnce.Do(func() { close(c.quit) })
+	c.connectMu.Lock()
+	defer c.connectMu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	exporter := c.exporter
+	c.exporter = nil
+	if exporter == nil && c.previous != nil {
+		// Never connected, the state of the collector it replaces is
+		// passed on.
+		c.previous.mu.Lock()
+		c.last = c.previous.last
+		c.previous.mu.Unlock()
+		c.previous, c.previousScrapes = nil, nil
+	}
+	if exporter == nil || c.handedOver {
+		return nil
+	}
+	c.last = exporter
+	return exporter.Close()
+}
+
+// collectorSet holds the collectors built from one version of the
+// configuration. The metrics of all clusters are registered in registry, and
+// the metrics of each cluster in a registry of its own used when a single
+// cluster is requested with the cluster URL parameter.
+type collectorSet struct {
+	cfg        *fileConfig
+	flags      kafkaOpts
+	registry   *prometheus.Registry
+	clusters   map[string]*prometheus.Registry
+	exporter   *Exporter
+	collectors []*clusterCollector
+	probes     *prober
+	closers    []func() error
+	// The hashes of the secret files the Kafka clients were created with.
+	// The reloader serializes their checks.
+	secrets secretWatch
+
+	// Held for reading while the set serves a scrape, and for writing when
+	// the set is closed or its Exporter replaced, so that in-flight scrapes
+	// complete first.
+	mu sync.RWMutex
+}
+
+// newCollectorSet builds the collectors of cfg. Without clusters, the top
+// level settings describe the only cluster, which must be reachable. The
+// collectors take over the state of the ones of the same clusters in
+// previous, the set being replaced, if any: the clusters once connected, and
+// the top level settings once handed over with handOver.
+func newCollectorSet(cfg *fileConfig, flags kafkaOpts, previous *collectorSet) (*collectorSet, error) {
+	set := &collectorSet{
+		cfg:      cfg,
+		flags:    flags,
+		registry: prometheus.NewRegistry(),
+		probes:   newProber(cfg, flags),
+		// Hashed before the Kafka clients read the files.
+		secrets: newSecretWatch(cfg),
+	}
+	set.closers = append(set.closers, set.probes.Close)
+
+	if len(cfg.Clusters) == 0 {
+		exporter, err := newExporter(cfg.Global.kafkaOpts(flags), cfg.Global.TopicFilter, cfg.Global.GroupFilter)
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+		set.exporter = exporter
+		// The Exporter is replaced when its secret files change, or handed
+		// over to the set replacing this one.
+		set.closers = append(set.closers, func() error {
+			if set.exporter == nil {
+				return nil
+			}
+			return set.exporter.Close()
+		})
+		if err := prometheus.WrapRegistererWith(cfg.Global.Labels, set.registry).Register(exporter); err != nil {
+			set.Close()
+			return nil, err
+		}
+		if previous == nil || previous.exporter == nil {
+			exporter.start()
+		}
+		return set, nil
+	}
+
+	var replaced map[string]*clusterCollector
+	if previous != nil {
+		replaced = make(map[string]*clusterCollector, len(previous.collectors))
+		for _, collector := range previous.collectors {
+			replaced[collector.name] = collector
+		}
+	}
+	set.clusters = make(map[string]*prometheus.Registry, len(cfg.Clusters))
+	for _, cluster := range cfg.Clusters {
+		collector := newClusterCollector(cluster, flags)
+		if replaced[cluster.Name] != nil {
+			collector.previous, collector.previousScrapes = replaced[cluster.Name], &previous.mu
+		}
+		set.collectors = append(set.collectors, collector)
+		set.closers = append(set.closers, collector.Close)
+		labels := prometheus.Labels{"cluster": cluster.Name}
+		for name, value := range cluster.Labels {
+			labels[name] = value
+		}
+		registry := prometheus.NewRegistry()
+		for _, registerer := range []prometheus.Registerer{set.registry, registry} {
+			if err := prometheus.WrapRegistererWith(labels, registerer).Register(collector); err != nil {
+				set.Close()
+				return nil, fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+		}
+		set.clusters[cluster.Name] = registry
+		glog.Infof("Registered cluster %s with brokers %v", cluster.Name, cluster.Brokers)
+	}
+	// Started once all are registered, so that a set that cannot be built
+	// does not take over the state of previous.
+	for _, collector := range set.collectors {
+		collector.start()
+	}
+	return set, nil
+}
+
+// waitConnected waits for the first connection attempt to the clusters, at
+// most timeout.
+func (s *collectorSet) waitConnected(timeout time.Duration) {
+	deadline := time.After(timeout)
+	for _, collector := range s.collectors {
+		select {
+		case <-collector.attempted:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// handOver stops the Exporter of the top level settings and passes its state
+// to the one of next, the set replacing this one, which is started. The
+// Exporters are swapped with scrapes locked, so that the scrapes in progress
+// complete first. The caller swaps next in before the scrapes can use this
+// set again.
+func (s *collectorSet) handOver(next *collectorSet) {
+	if s.exporter == nil || next.exporter == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.exporter.Close(); err != nil {
+		glog.Errorf("Cannot close Kafka client: %v", err)
+	}
+	next.exporter.inherit(s.exporter)
+	next.exporter.start()
+	s.exporter = nil
+}
+
+// refreshSecrets recreates the Kafka clients of the clusters and modules
+// whose secret files changed, each keeping the state of the Exporters it
+// replaces.
+func (s *collectorSet) refreshSecrets() {
+	if s.exporter != nil {
+		s.secrets.global = refreshSecrets("the exporter", s.secrets.global, s.reconnect)
+	}
+	for _, collector := range s.collectors {
+		s.secrets.clusters[collector.name] = refreshSecrets("cluster "+collector.name, s.secrets.clusters[collector.name], func() error {
+			return collector.reconnect(&s.mu)
+		})
+	}
+	for name, hashes := range s.secrets.modules {
+		name := name
+		s.secrets.modules[name] = refreshSecrets("module "+name, hashes, func() error {
+			s.probes.reconnect(name)
+			return nil
+		})
+	}
+}
+
+// reconnect replaces the Exporter of the top level settings by one with a
+// new Kafka client, which takes over the state of the current one.
+func (s *collectorSet) reconnect() error {
+	exporter, err := newExporter(s.cfg.Global.kafkaOpts(s.flags), s.cfg.Global.TopicFilter, s.cfg.Global.GroupFilter)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous := s.exporter
+	registerer := prometheus.WrapRegistererWith(s.cfg.Global.Labels, s.registry)
+	registerer.Unregister(previous)
+	if err := previous.Close(); err != nil {
+		glog.Errorf("Cannot close Kafka client: %v", err)
+	}
+	exporter.inherit(previous)
+	exporter.start()
+	s.exporter = exporter
+	registerer.MustRegister(exporter)
+	return nil
+}
+
+// ServeHTTP serves the merged metrics of all clusters, or the metrics of a
+// single cluster when the cluster URL parameter is given. The caller holds
+// mu for reading.
+func (s *collectorSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gatherer := prometheus.Gatherers{prometheus.DefaultGatherer, s.registry}
+	if name := r.URL.Query().Get("cluster"); name != "" && s.clusters != nil {
+		registry, ok := s.clusters[name]
+		if !ok {
+			http.Error(w, "unknown cluster "+name, http.StatusNotFound)
+			return
+		}
+		gatherer = prometheus.Gatherers{registry}
+	}
+	promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// livenessChecks returns the liveness checks of the clusters. The clusters
+// that are not connected yet are skipped. The caller holds mu for reading.
+func (s *collectorSet) livenessChecks(now time.Time) []healthCheck {
+	if s.exporter != nil {
+		return s.exporter.livenessChecks(now)
+	}
+	var checks []healthCheck
+	for _, collector := range s.collectors {
+		if exporter := collector.current(); exporter != nil {
+			checks = append(checks, inCluster(collector.name, exporter.livenessChecks(now))...)
+		}
+	}
+	return checks
+}
+
+// readinessChecks returns the readiness checks of the clusters. A cluster
+// that is not connected yet fails without dialing it, the background
+// connection being in charge of that. The clusters are checked concurrently.
+// The caller holds mu for reading.
+func (s *collectorSet) readinessChecks(now time.Time) []healthCheck {
+	if s.exporter != nil {
+		return s.exporter.readinessChecks(now)
+	}
+	results := make([][]healthCheck, len(s.collectors))
+	var wg sync.WaitGroup
+	for i, collector := range s.collectors {
+		wg.Add(1)
+		go func(i int, collector *clusterCollector) {
+			defer wg.Done()
+			exporter, err := collector.state()
+			switch {
+			case exporter == nil && err != nil:
+				results[i] = inCluster(collector.name, []healthCheck{failed("connection", "%v", err)})
+				return
+			case exporter == nil:
+				results[i] = inCluster(collector.name, []healthCheck{failed("connection", "not connected yet")})
+				return
+			}
+			results[i] = inCluster(collector.name, exporter.readinessChecks(now))
+		}(i, collector)
+	}
+	wg.Wait()
+
+	var checks []healthCheck
+	for _, result := range results {
+		checks = append(checks, result...)
+	}
+	return checks
+}
+
+func inCluster(name string, checks []healthCheck) []healthCheck {
+	for i := range checks {
+		checks[i].Cluster = name
+	}
+	return checks
+}
+
+// Close waits for the in-flight scrapes and releases the Kafka clients.
+func (s *collectorSet) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, close := range s.closers {
+		if err := close(); err != nil {
+			glog.Errorf("Cannot close Kafka client: %v", err)
+		}
+	}
+}
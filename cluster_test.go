@@ -0,0 +1,201 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestClusterCollectorUnreachable(t *testing.T) {
+	exporterClusterUp = testDesc("up")
+
+	cfg := testDefaults()
+	cfg.Name = "unreachable"
+	cfg.Brokers = []string{"localhost:0"}
+	c := newClusterCollector(cfg, kafkaOpts{})
+	c.start()
+
+	// The scrapes do not wait for the connection.
+	start := time.Now()
+	metrics := collectTestMetrics(t, map[*prometheus.Desc]string{exporterClusterUp: "up"}, c.Collect)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected the scrape not to connect, took %v", elapsed)
+	}
+	if len(metrics) != 1 || metrics["up{}"] != 0 {
+		t.Errorf("expected only the cluster to be down, got %v", metrics)
+	}
+
+	// Neither does the readiness check.
+	set := &collectorSet{collectors: []*clusterCollector{c}}
+	start = time.Now()
+	checks := set.readinessChecks(start)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected the readiness check not to connect, took %v", elapsed)
+	}
+	if len(checks) != 1 || checks[0].Name != "connection" || checks[0].Status != healthFailed {
+		t.Errorf("expected the connection check to fail, got %+v", checks)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- c.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to stop connecting")
+	}
+	if _, err := c.getExporter(); err == nil {
+		t.Error("expected a closed cluster not to connect")
+	}
+}
+
+func TestClusterCollectorReconnect(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	cfg := testDefaults()
+	cfg.Name = "a"
+	cfg.Brokers = []string{broker.Addr()}
+	c := newClusterCollector(cfg, kafkaOpts{})
+	defer c.Close()
+	previous, err := c.getExporter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.topicRates.observe(rateKey{topic: "orders"}, 10, time.Now())
+
+	if err := c.reconnect(&sync.Mutex{}); err != nil {
+		t.Fatal(err)
+	}
+	exporter := c.current()
+	if exporter == previous || !previous.client.Closed() {
+		t.Fatal("expected the Exporter to be replaced and closed")
+	}
+	if exporter.topicRates != previous.topicRates || exporter.groupStatus != previous.groupStatus {
+		t.Error("expected the state of the previous Exporter to be kept")
+	}
+}
+
+func TestClusterCollectorTakesOver(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	cfg := testDefaults()
+	cfg.Name = "a"
+	cfg.Brokers = []string{broker.Addr()}
+	old := &collectorSet{collectors: []*clusterCollector{newClusterCollector(cfg, kafkaOpts{})}}
+	previous, err := old.collectors[0].getExporter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.topicRates.observe(rateKey{topic: "orders"}, 10, time.Now())
+
+	c := newClusterCollector(cfg, kafkaOpts{})
+	c.previous, c.previousScrapes = old.collectors[0], &old.mu
+	set := &collectorSet{collectors: []*clusterCollector{c}}
+	defer set.Close()
+	c.start()
+	set.waitConnected(5 * time.Second)
+
+	// The old collector exports the cluster with the new Exporter until it
+	// is closed.
+	exporter := c.current()
+	if exporter == nil || old.collectors[0].current() != exporter || !previous.client.Closed() {
+		t.Fatal("expected the Exporter to be handed over")
+	}
+	if exporter.topicRates != previous.topicRates {
+		t.Error("expected the state of the previous Exporter to be kept")
+	}
+	if err := old.collectors[0].Close(); err != nil {
+		t.Error(err)
+	}
+	if exporter.client.Closed() {
+		t.Error("expected the handed over Exporter not to be closed with the old collector")
+	}
+}
+
+func TestClusterCollectorTakesOverClosed(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	cfg := testDefaults()
+	cfg.Name = "a"
+	cfg.Brokers = []string{broker.Addr()}
+	old := newClusterCollector(cfg, kafkaOpts{})
+	previous, err := old.getExporter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.topicRates.observe(rateKey{topic: "orders"}, 10, time.Now())
+
+	// A collector that is closed before connecting passes the state on.
+	unreachable := cfg
+	unreachable.Brokers = []string{"localhost:0"}
+	skipped := newClusterCollector(unreachable, kafkaOpts{})
+	skipped.previous, skipped.previousScrapes = old, &sync.Mutex{}
+	if err := old.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := skipped.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClusterCollector(cfg, kafkaOpts{})
+	c.previous, c.previousScrapes = skipped, &sync.Mutex{}
+	defer c.Close()
+	exporter, err := c.getExporter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exporter.topicRates != previous.topicRates {
+		t.Error("expected the state of the closed Exporter to be kept")
+	}
+}
+
+func TestCollectorSetHandOver(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	opts := testDefaults().kafkaOpts(kafkaOpts{})
+	opts.uri = []string{broker.Addr()}
+	previous, err := newExporter(opts, ".*", ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.groupMessages.observe(offsetCounterKey{}, 1)
+	exporter, err := newExporter(opts, ".*", ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+
+	old := &collectorSet{exporter: previous}
+	old.handOver(&collectorSet{exporter: exporter})
+	if !previous.client.Closed() || exporter.client.Closed() || old.exporter != nil {
+		t.Error("expected the previous Exporter to be closed and released")
+	}
+	if exporter.groupMessages != previous.groupMessages {
+		t.Error("expected the state of the previous Exporter to be kept")
+	}
+}
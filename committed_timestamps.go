@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// committedOffset identifies the record at a committed offset.
+type committedOffset struct {
+	topic     string
+	partition int32
+	offset    int64
+}
+
+// groupCommit is the offset a consumer group committed on a partition.
+type groupCommit struct {
+	group string
+	committedOffset
+}
+
+// committedTimestampCache keeps the timestamps of the records at the offsets
+// committed by the consumer groups, so that the records are only fetched
+// again when the groups commit. Offsets that were not committed anymore
+// during the last scrape are forgotten when the scrape ends.
+type committedTimestampCache struct {
+	mu       sync.Mutex
+	previous map[committedOffset]time.Time
+	current  map[committedOffset]time.Time
+}
+
+func newCommittedTimestampCache() *committedTimestampCache {
+	return &committedTimestampCache{
+		previous: make(map[committedOffset]time.Time),
+		current:  make(map[committedOffset]time.Time),
+	}
+}
+
+func (c *committedTimestampCache) get(key committedOffset) (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timestamp, ok := c.current[key]; ok {
+		return timestamp, true
+	}
+	timestamp, ok := c.previous[key]
+	if ok {
+		c.current[key] = timestamp
+	}
+	return timestamp, ok
+}
+
+func (c *committedTimestampCache) set(key committedOffset, timestamp time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current[key] = timestamp
+}
+
+// rotate ends a scrape.
+func (c *committedTimestampCache) rotate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.previous = c.current
+	c.current = make(map[committedOffset]time.Time, len(c.previous))
+}
+
+// collectCommittedTimestampMetrics exports the timestamp of the record at the
+// offset committed by each consumer group, i.e. the next record the group
+// will consume. The records missing from the cache are fetched from the
+// partition leaders, which are queried concurrently.
+func (e *Exporter) collectCommittedTimestampMetrics(ch chan<- prometheus.Metric, commits []groupCommit) {
+	defer e.committedTimestamps.rotate()
+
+	missing := make(map[*sarama.Broker]map[committedOffset]bool)
+	for _, commit := range commits {
+		if _, ok := e.committedTimestamps.get(commit.committedOffset); ok {
+			continue
+		}
+		broker, err := e.client.Leader(commit.topic, commit.partition)
+		if err != nil {
+			e.scrapeErrorf(phaseGroups, "Cannot get leader of topic %s partition %d: %v", commit.topic, commit.partition, err)
+			continue
+		}
+		if _, ok := missing[broker]; !ok {
+			missing[broker] = make(map[committedOffset]bool)
+		}
+		missing[broker][commit.committedOffset] = true
+	}
+
+	var wg sync.WaitGroup
+	for broker, offsets := range missing {
+		wg.Add(1)
+		go func(broker *sarama.Broker, offsets map[committedOffset]bool) {
+			defer wg.Done()
+			e.fetchCommittedTimestamps(broker, offsets)
+		}(broker, offsets)
+	}
+	wg.Wait()
+
+	for _, commit := range commits {
+		timestamp, ok := e.committedTimestamps.get(commit.committedOffset)
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupCommittedTimestamp, prometheus.GaugeValue, float64(timestamp.UnixNano())/1e9, commit.group, commit.topic, strconv.FormatInt(int64(commit.partition), 10),
+		)
+	}
+}
+
+// fetchCommittedTimestamps fetches the records at the given offsets from
+// their leader and caches their timestamps. A Fetch request reads a single
+// offset per partition, so the partitions committed at several offsets by
+// different groups take several rounds.
+func (e *Exporter) fetchCommittedTimestamps(broker *sarama.Broker, offsets map[committedOffset]bool) {
+	for len(offsets) > 0 {
+		targets := make(map[string]map[int32]int64)
+		for key := range offsets {
+			if _, ok := targets[key.topic][key.partition]; ok {
+				continue
+			}
+			addTarget(targets, key.topic, key.partition, key.offset)
+			delete(offsets, key)
+		}
+		for topic, partitions := range e.fetchRecordTimestamps(broker, targets) {
+			for partition, timestamp := range partitions {
+				e.committedTimestamps.set(committedOffset{topic: topic, partition: partition, offset: targets[topic][partition]}, timestamp)
+			}
+		}
+	}
+}
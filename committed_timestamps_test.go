@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommittedTimestampCacheRotate(t *testing.T) {
+	c := newCommittedTimestampCache()
+	kept := committedOffset{topic: "orders", partition: 0, offset: 10}
+	dropped := committedOffset{topic: "orders", partition: 1, offset: 20}
+	c.set(kept, time.Unix(1600000000, 0))
+	c.set(dropped, time.Unix(1600000001, 0))
+	c.rotate()
+
+	// Only the offsets still committed during a scrape survive the next one.
+	if timestamp, ok := c.get(kept); !ok || !timestamp.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("expected cached timestamp, got %v %v", timestamp, ok)
+	}
+	c.rotate()
+	if _, ok := c.get(kept); !ok {
+		t.Error("expected offset read during the previous scrape to be cached")
+	}
+	if _, ok := c.get(dropped); ok {
+		t.Error("expected offset not committed anymore to be forgotten")
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the content of the file given with --config.file.
+//
+// The top level of the file holds the same settings as the command line
+// flags, and overrides them. When clusters are listed, the top level settings
+// are the defaults of every cluster, otherwise they describe the only cluster
+// to scrape. Modules are named settings without brokers, used to scrape the
+// targets given to the probe endpoint.
+type fileConfig struct {
+	Global   clusterConfig
+	Clusters []clusterConfig
+	Modules  map[string]clusterConfig
+}
+
+// clusterConfig holds the settings of a Kafka cluster to scrape.
+type clusterConfig struct {
+	Name                    string            `yaml:"name"`
+	Brokers                 []string          `yaml:"brokers"`
+	KafkaVersion            string            `yaml:"kafka_version"`
+	TopicFilter             string            `yaml:"topic_filter"`
+	GroupFilter             string            `yaml:"group_filter"`
+	GroupNames              []string          `yaml:"group_names"`
+	Labels                  map[string]string `yaml:"labels"`
+	SASL                    saslConfig        `yaml:"sasl"`
+	TLS                     tlsConfig         `yaml:"tls"`
+	ZooKeeper               zooKeeperConfig   `yaml:"zookeeper"`
+	MetadataRefreshInterval string            `yaml:"metadata_refresh_interval"`
+	ScrapeInterval          string            `yaml:"scrape_interval"`
+	OffsetShowAll           bool              `yaml:"offset_show_all"`
+	AllowConcurrent         bool              `yaml:"concurrent_enabled"`
+	TopicWorkers            int               `yaml:"topic_workers"`
+	LagHistorySize          int               `yaml:"lag_history_size"`
+	GroupStatusWindow       int               `yaml:"group_status_window"`
+	TopicConfig             topicConfigConfig `yaml:"topic_config"`
+	LogDirsEnabled          bool              `yaml:"log_dirs_enabled"`
+	TimestampsEnabled       bool              `yaml:"topic_timestamps_enabled"`
+	TimestampMaxRequests    int               `yaml:"topic_timestamps_max_requests"`
+	CommittedTimestamps     bool              `yaml:"group_committed_timestamp_enabled"`
+	// ProbeTargets restricts the probe targets a module scrapes, every
+	// bootstrap server of the target must match it.
+	ProbeTargets string `yaml:"probe_targets"`
+
+	// The names of the labels given with --kafka.labels, which are constant
+	// labels of every metric and cannot be set again.
+	flagLabels map[string]string
+}
+
+type saslConfig struct {
+	Enabled            bool              `yaml:"enabled"`
+	Handshake          bool              `yaml:"handshake"`
+	Mechanism          string            `yaml:"mechanism"`
+	Username           string            `yaml:"username"`
+	Password           string            `yaml:"password"`
+	PasswordFile       string            `yaml:"password_file"`
+	ServiceName        string            `yaml:"service_name"`
+	KerberosConfigPath string            `yaml:"kerberos_config_path"`
+	Realm              string            `yaml:"realm"`
+	KeyTabPath         string            `yaml:"keytab_path"`
+	KerberosAuthType   string            `yaml:"kerberos_auth_type"`
+	OAuthBearer        oauthBearerConfig `yaml:"oauthbearer"`
+}
+
+type oauthBearerConfig struct {
+	TokenURL         string   `yaml:"token_url"`
+	ClientID         string   `yaml:"client_id"`
+	ClientSecret     string   `yaml:"client_secret"`
+	ClientSecretFile string   `yaml:"client_secret_file"`
+	Scopes           []string `yaml:"scopes"`
+	TokenFile        string   `yaml:"token_file"`
+	TokenCommand     string   `yaml:"token_command"`
+}
+
+type tlsConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	KeyPassword        string `yaml:"key_password"`
+	KeyPasswordFile    string `yaml:"key_password_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type zooKeeperConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Servers []string `yaml:"servers"`
+}
+
+type topicConfigConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Names   []string `yaml:"names"`
+}
+
+// newClusterConfig returns the settings given on the command line.
+func newClusterConfig(opts kafkaOpts, topicFilter string, groupFilter string) clusterConfig {
+	return clusterConfig{
+		Brokers:      opts.uri,
+		KafkaVersion: opts.kafkaVersion,
+		TopicFilter:  topicFilter,
+		GroupFilter:  groupFilter,
+		GroupNames:   opts.groupNames,
+		SASL: saslConfig{
+			Enabled:            opts.useSASL,
+			Handshake:          opts.useSASLHandshake,
+			Mechanism:          opts.saslMechanism,
+			Username:           opts.saslUsername,
+			Password:           opts.saslPassword,
+			PasswordFile:       opts.saslPasswordFile,
+			ServiceName:        opts.serviceName,
+			KerberosConfigPath: opts.kerberosConfigPath,
+			Realm:              opts.realm,
+			KeyTabPath:         opts.keyTabPath,
+			KerberosAuthType:   opts.kerberosAuthType,
+			OAuthBearer: oauthBearerConfig{
+				TokenURL:         opts.oauthTokenURL,
+				ClientID:         opts.oauthClientID,
+				ClientSecret:     opts.oauthClientSecret,
+				ClientSecretFile: opts.oauthClientSecretFile,
+				Scopes:           opts.oauthScopes,
+				TokenFile:        opts.oauthTokenFile,
+				TokenCommand:     opts.oauthTokenCommand,
+			},
+		},
+		TLS: tlsConfig{
+			Enabled:            opts.useTLS,
+			CAFile:             opts.tlsCAFile,
+			CertFile:           opts.tlsCertFile,
+			KeyFile:            opts.tlsKeyFile,
+			KeyPassword:        opts.tlsKeyPassword,
+			KeyPasswordFile:    opts.tlsKeyPasswordFile,
+			InsecureSkipVerify: opts.tlsInsecureSkipTLSVerify,
+		},
+		ZooKeeper: zooKeeperConfig{
+			Enabled: opts.useZooKeeperLag,
+			Servers: opts.uriZookeeper,
+		},
+		MetadataRefreshInterval: opts.metadataRefreshInterval,
+		ScrapeInterval:          opts.scrapeInterval,
+		OffsetShowAll:           opts.offsetShowAll,
+		AllowConcurrent:         opts.allowConcurrent,
+		TopicWorkers:            opts.topicWorkers,
+		LagHistorySize:          opts.lagHistorySize,
+		GroupStatusWindow:       opts.groupStatusWindow,
+		TopicConfig: topicConfigConfig{
+			Enabled: opts.topicConfigEnabled,
+			Names:   opts.topicConfigNames,
+		},
+		LogDirsEnabled:       opts.logDirsEnabled,
+		TimestampsEnabled:    opts.timestampsEnabled,
+		TimestampMaxRequests: opts.timestampMaxRequests,
+		CommittedTimestamps:  opts.committedTimestampsEnabled,
+		flagLabels:           parseLabels(opts.labels),
+	}
+}
+
+// parseLabels parses the labels given with --kafka.labels, as comma separated
+// name=value pairs.
+func parseLabels(labels string) map[string]string {
+	parsed := make(map[string]string)
+	// Protect against empty labels
+	if labels != "" {
+		for _, label := range strings.Split(labels, ",") {
+			splitted := strings.Split(label, "=")
+			if len(splitted) >= 2 {
+				parsed[splitted[0]] = splitted[1]
+			}
+		}
+	}
+	return parsed
+}
+
+// kafkaOpts returns the options to connect to the cluster. The options that
+// only exist on the command line are taken from flags.
+func (c clusterConfig) kafkaOpts(flags kafkaOpts) kafkaOpts {
+	opts := flags
+	opts.uri = c.Brokers
+	opts.kafkaVersion = c.KafkaVersion
+	opts.groupNames = c.GroupNames
+	opts.useSASL = c.SASL.Enabled
+	opts.useSASLHandshake = c.SASL.Handshake
+	opts.saslMechanism = c.SASL.Mechanism
+	opts.saslUsername = c.SASL.Username
+	opts.saslPassword = c.SASL.Password
+	opts.saslPasswordFile = c.SASL.PasswordFile
+	opts.serviceName = c.SASL.ServiceName
+	opts.kerberosConfigPath = c.SASL.KerberosConfigPath
+	opts.realm = c.SASL.Realm
+	opts.keyTabPath = c.SASL.KeyTabPath
+	opts.kerberosAuthType = c.SASL.KerberosAuthType
+	opts.oauthTokenURL = c.SASL.OAuthBearer.TokenURL
+	opts.oauthClientID = c.SASL.OAuthBearer.ClientID
+	opts.oauthClientSecret = c.SASL.OAuthBearer.ClientSecret
+	opts.oauthClientSecretFile = c.SASL.OAuthBearer.ClientSecretFile
+	opts.oauthScopes = c.SASL.OAuthBearer.Scopes
+	opts.oauthTokenFile = c.SASL.OAuthBearer.TokenFile
+	opts.oauthTokenCommand = c.SASL.OAuthBearer.TokenCommand
+	opts.useTLS = c.TLS.Enabled
+	opts.tlsCAFile = c.TLS.CAFile
+	opts.tlsCertFile = c.TLS.CertFile
+	opts.tlsKeyFile = c.TLS.KeyFile
+	opts.tlsKeyPassword = c.TLS.KeyPassword
+	opts.tlsKeyPasswordFile = c.TLS.KeyPasswordFile
+	opts.tlsInsecureSkipTLSVerify = c.TLS.InsecureSkipVerify
+	opts.useZooKeeperLag = c.ZooKeeper.Enabled
+	opts.uriZookeeper = c.ZooKeeper.Servers
+	opts.metadataRefreshInterval = c.MetadataRefreshInterval
+	opts.scrapeInterval = c.ScrapeInterval
+	opts.offsetShowAll = c.OffsetShowAll
+	opts.allowConcurrent = c.AllowConcurrent
+	opts.topicWorkers = c.TopicWorkers
+	opts.lagHistorySize = c.LagHistorySize
+	opts.groupStatusWindow = c.GroupStatusWindow
+	opts.topicConfigEnabled = c.TopicConfig.Enabled
+	opts.topicConfigNames = c.TopicConfig.Names
+	opts.logDirsEnabled = c.LogDirsEnabled
+	opts.timestampsEnabled = c.TimestampsEnabled
+	opts.timestampMaxRequests = c.TimestampMaxRequests
+	opts.committedTimestampsEnabled = c.CommittedTimestamps
+	return opts
+}
+
+// clone returns a copy of c that does not share its labels, so that
+// unmarshalling over the copy does not modify c.
+func (c clusterConfig) clone() clusterConfig {
+	labels := make(map[string]string, len(c.Labels))
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+	c.Labels = labels
+	return c
+}
+
+// validate checks the settings that would otherwise only fail when the
+// cluster is scraped.
+func (c clusterConfig) validate() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("no brokers")
+	}
+	return c.validateSettings()
+}
+
+// validateSettings checks the settings other than the brokers.
+func (c clusterConfig) validateSettings() error {
+	if _, err := regexp.Compile(c.TopicFilter); err != nil {
+		return fmt.Errorf("invalid topic filter: %v", err)
+	}
+	if _, err := regexp.Compile(c.GroupFilter); err != nil {
+		return fmt.Errorf("invalid group filter: %v", err)
+	}
+	if _, ok := c.Labels["cluster"]; ok {
+		return fmt.Errorf("the cluster label is reserved")
+	}
+	for name := range c.Labels {
+		if _, ok := c.flagLabels[name]; ok {
+			return fmt.Errorf("the %s label is already set with --kafka.labels", name)
+		}
+	}
+	if _, ok := c.flagLabels["cluster"]; ok && c.Name != "" {
+		return fmt.Errorf("the cluster label of --kafka.labels is reserved when clusters are listed")
+	}
+	if _, err := time.ParseDuration(c.MetadataRefreshInterval); err != nil {
+		return fmt.Errorf("invalid metadata refresh interval: %v", err)
+	}
+	if c.ScrapeInterval != "" {
+		if _, err := time.ParseDuration(c.ScrapeInterval); err != nil {
+			return fmt.Errorf("invalid scrape interval: %v", err)
+		}
+	}
+	if c.TopicWorkers < 1 {
+		return fmt.Errorf("topic workers must be at least 1")
+	}
+	return nil
+}
+
+// probeTargets returns the regexp the bootstrap servers of the probe targets
+// of a module must fully match, or nil when any target is allowed.
+func (c clusterConfig) probeTargets() (*regexp.Regexp, error) {
+	if c.ProbeTargets == "" {
+		return nil, nil
+	}
+	return regexp.Compile("^(?:" + c.ProbeTargets + ")$")
+}
+
+// loadConfigFile reads and validates the configuration file at path. The
+// settings missing from the file are taken from defaults.
+func loadConfigFile(path string, defaults clusterConfig) (*fileConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(content, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+func parseConfig(content []byte, defaults clusterConfig) (*fileConfig, error) {
+	raw := struct {
+		clusterConfig `yaml:",inline"`
+		// Kept raw to be unmarshalled over the top level settings.
+		Clusters []yaml.MapSlice          `yaml:"clusters"`
+		Modules  map[string]yaml.MapSlice `yaml:"modules"`
+	}{clusterConfig: defaults.clone()}
+	if err := yaml.UnmarshalStrict(content, &raw); err != nil {
+		return nil, err
+	}
+	if raw.Name != "" {
+		return nil, fmt.Errorf("name is only valid in clusters")
+	}
+	if raw.ProbeTargets != "" {
+		return nil, fmt.Errorf("probe_targets is only valid in modules")
+	}
+
+	cfg := &fileConfig{Global: raw.clusterConfig}
+	if len(raw.Modules) > 0 {
+		cfg.Modules = make(map[string]clusterConfig, len(raw.Modules))
+	}
+	for name, settings := range raw.Modules {
+		module, err := cfg.Global.inherit(settings)
+		if err != nil {
+			return nil, fmt.Errorf("module %q: %v", name, err)
+		}
+		if module.Name != "" || module.Brokers != nil {
+			return nil, fmt.Errorf("module %q: the name and brokers are given by the probe target", name)
+		}
+		if _, err := module.probeTargets(); err != nil {
+			return nil, fmt.Errorf("module %q: invalid probe targets: %v", name, err)
+		}
+		if err := module.validateSettings(); err != nil {
+			return nil, fmt.Errorf("module %q: %v", name, err)
+		}
+		cfg.Modules[name] = module
+	}
+
+	if len(raw.Clusters) == 0 {
+		if err := cfg.Global.validate(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	seen := make(map[string]bool, len(raw.Clusters))
+	for i, settings := range raw.Clusters {
+		cluster, err := cfg.Global.inherit(settings)
+		if err != nil {
+			return nil, fmt.Errorf("cluster #%d: %v", i, err)
+		}
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("cluster #%d has no name", i)
+		}
+		if cluster.ProbeTargets != "" {
+			return nil, fmt.Errorf("cluster %q: probe_targets is only valid in modules", cluster.Name)
+		}
+		if seen[cluster.Name] {
+			return nil, fmt.Errorf("cluster %q is defined more than once", cluster.Name)
+		}
+		seen[cluster.Name] = true
+		if err := cluster.validate(); err != nil {
+			return nil, fmt.Errorf("cluster %q: %v", cluster.Name, err)
+		}
+		cfg.Clusters = append(cfg.Clusters, cluster)
+	}
+	return cfg, nil
+}
+
+// inherit returns the settings of a cluster or module, the ones missing from
+// settings being taken from c. The brokers identify a cluster, they are
+// never inherited.
+func (c clusterConfig) inherit(settings yaml.MapSlice) (clusterConfig, error) {
+	content, err := yaml.Marshal(settings)
+	if err != nil {
+		return clusterConfig{}, err
+	}
+	cluster := c.clone()
+	cluster.Brokers = nil
+	if err := yaml.UnmarshalStrict(content, &cluster); err != nil {
+		return clusterConfig{}, err
+	}
+	return cluster, nil
+}
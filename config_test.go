@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testDefaults returns the settings given by the default flags.
+func testDefaults() clusterConfig {
+	return newClusterConfig(kafkaOpts{
+		uri:                     []string{"kafka:9092"},
+		useSASLHandshake:        true,
+		kafkaVersion:            "2.0.0",
+		metadataRefreshInterval: "30s",
+		topicWorkers:            100,
+	}, ".*", ".*")
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+clusters:
+  - name: prod
+    brokers: [kafka-1:9092, kafka-2:9092]
+    topic_filter: "^orders"
+    labels:
+      env: prod
+    sasl:
+      enabled: true
+      mechanism: scram-sha512
+      username: exporter
+      password: secret
+  - name: staging
+    brokers: [kafka-staging:9092]
+    kafka_version: 1.0.0
+`)
+	cfg, err := loadConfigFile(path, testDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(cfg.Clusters))
+	}
+
+	flags := kafkaOpts{verbosityLogLevel: 2}
+	prod := cfg.Clusters[0].kafkaOpts(flags)
+	if !prod.useSASL || !prod.useSASLHandshake || prod.saslUsername != "exporter" {
+		t.Errorf("unexpected SASL options for prod: %+v", prod)
+	}
+	if prod.kafkaVersion != "2.0.0" || prod.topicWorkers != 100 || prod.verbosityLogLevel != 2 {
+		t.Errorf("prod did not inherit defaults: %+v", prod)
+	}
+	if cfg.Clusters[0].TopicFilter != "^orders" || cfg.Clusters[0].GroupFilter != ".*" {
+		t.Errorf("unexpected filters for prod: %+v", cfg.Clusters[0])
+	}
+
+	staging := cfg.Clusters[1].kafkaOpts(flags)
+	if staging.kafkaVersion != "1.0.0" || staging.useSASL {
+		t.Errorf("unexpected options for staging: %+v", staging)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"top level name":          `name: a`,
+		"bad top level":           `topic_workers: 0`,
+		"missing name":            `clusters: [{brokers: [kafka:9092]}]`,
+		"duplicate name":          `clusters: [{name: a, brokers: [kafka:9092]}, {name: a, brokers: [kafka:9092]}]`,
+		"no brokers":              `clusters: [{name: a}]`,
+		"bad filter":              `clusters: [{name: a, brokers: [kafka:9092], topic_filter: "("}]`,
+		"reserved label":          `clusters: [{name: a, brokers: [kafka:9092], labels: {cluster: b}}]`,
+		"unknown field":           `clusters: [{name: a, brokers: [kafka:9092], bogus: true}]`,
+		"module brokers":          `modules: {a: {brokers: [kafka:9092]}}`,
+		"bad module":              `modules: {a: {topic_workers: 0}}`,
+		"probe targets":           `clusters: [{name: a, brokers: [kafka:9092], probe_targets: kafka}]`,
+		"top level probe targets": `probe_targets: kafka`,
+		"bad probe targets":       `modules: {a: {probe_targets: "("}}`,
+	} {
+		if _, err := loadConfigFile(writeConfigFile(t, content), testDefaults()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadConfigFileFlagLabels(t *testing.T) {
+	defaults := newClusterConfig(kafkaOpts{
+		uri:                     []string{"kafka:9092"},
+		kafkaVersion:            "2.0.0",
+		metadataRefreshInterval: "30s",
+		topicWorkers:            100,
+		labels:                  "env=prod,cluster=main",
+	}, ".*", ".*")
+	for name, content := range map[string]string{
+		"top level label": `labels: {env: test}`,
+		"cluster label":   `clusters: [{name: a, brokers: [kafka:9092], labels: {env: test}}]`,
+		"module label":    `modules: {a: {labels: {env: test}}}`,
+		"cluster flag":    `clusters: [{name: a, brokers: [kafka:9092]}]`,
+	} {
+		if _, err := loadConfigFile(writeConfigFile(t, content), defaults); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// The cluster label of the flag names the only cluster.
+	if _, err := loadConfigFile(writeConfigFile(t, `labels: {team: data}`), defaults); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadConfigFileTopLevel(t *testing.T) {
+	path := writeConfigFile(t, `
+kafka_version: 2.8.0
+group_filter: "^app-"
+labels:
+  env: prod
+sasl:
+  enabled: true
+  username: exporter
+  password: secret
+`)
+	cfg, err := loadConfigFile(path, testDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Clusters) != 0 {
+		t.Fatalf("expected no clusters, got %d", len(cfg.Clusters))
+	}
+	opts := cfg.Global.kafkaOpts(kafkaOpts{})
+	if opts.kafkaVersion != "2.8.0" || !opts.useSASL || opts.saslPassword != "secret" {
+		t.Errorf("file settings did not override flags: %+v", opts)
+	}
+	if len(opts.uri) != 1 || opts.uri[0] != "kafka:9092" || opts.metadataRefreshInterval != "30s" {
+		t.Errorf("flags were not kept: %+v", opts)
+	}
+	if cfg.Global.GroupFilter != "^app-" || cfg.Global.Labels["env"] != "prod" {
+		t.Errorf("unexpected filters or labels: %+v", cfg.Global)
+	}
+}
+
+func TestLoadConfigFileInheritance(t *testing.T) {
+	path := writeConfigFile(t, `
+topic_workers: 10
+labels:
+  team: data
+clusters:
+  - name: a
+    brokers: [kafka-a:9092]
+  - name: b
+    brokers: [kafka-b:9092]
+    topic_workers: 5
+    labels:
+      env: test
+`)
+	cfg, err := loadConfigFile(path, testDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := cfg.Clusters[0], cfg.Clusters[1]
+	if a.TopicWorkers != 10 || b.TopicWorkers != 5 {
+		t.Errorf("unexpected topic workers: %d, %d", a.TopicWorkers, b.TopicWorkers)
+	}
+	if a.Labels["team"] != "data" || b.Labels["team"] != "data" || b.Labels["env"] != "test" {
+		t.Errorf("unexpected labels: %v, %v", a.Labels, b.Labels)
+	}
+	if _, ok := a.Labels["env"]; ok {
+		t.Errorf("labels of cluster b leaked into cluster a: %v", a.Labels)
+	}
+}
+
+func TestLoadConfigFileModules(t *testing.T) {
+	path := writeConfigFile(t, `
+kafka_version: 1.0.0
+modules:
+  prod_sasl:
+    sasl:
+      enabled: true
+      mechanism: plain
+      username: exporter
+`)
+	cfg, err := loadConfigFile(path, testDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, ok := cfg.Modules["prod_sasl"]
+	if !ok {
+		t.Fatalf("expected module prod_sasl, got %v", cfg.Modules)
+	}
+	opts := module.kafkaOpts(kafkaOpts{})
+	if !opts.useSASL || opts.saslUsername != "exporter" || opts.kafkaVersion != "1.0.0" || opts.uri != nil {
+		t.Errorf("unexpected module options: %+v", opts)
+	}
+}
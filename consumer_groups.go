@@ -0,0 +1,661 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// consumerGroupStates are the states a consumer group can be in. Brokers
+// older than 2.0 report CompletingRebalance as AwaitingSync.
+var consumerGroupStates = []string{"Stable", "PreparingRebalance", "CompletingRebalance", "Empty", "Dead"}
+
+// groupScrape holds the topic data of the current scrape that the consumer
+// group metrics are computed from.
+type groupScrape struct {
+	topicsPartitions map[string][]int32
+	offsets          map[string]map[int32]int64
+	produceRates     map[string]float64
+
+	// The offsets committed by the groups, when the timestamps of the
+	// committed records are collected.
+	commitsMu sync.Mutex
+	commits   []groupCommit
+}
+
+// collectConsumerGroupMetrics exports the metrics of the consumer groups
+// matching the group filter. Each group is described and its offsets are
+// fetched from its coordinator, as found with FindCoordinator. The client
+// caches the coordinators, and the groups whose coordinator moved since are
+// retried once with a fresh one.
+func (e *Exporter) collectConsumerGroupMetrics(ch chan<- prometheus.Metric, scrape *groupScrape) {
+	groupIDs, filtered := e.listConsumerGroups()
+	ch <- prometheus.MustNewConstMetric(
+		exporterFilteredGroups, prometheus.GaugeValue, float64(filtered),
+	)
+	// The counters of the groups that were not listed are forgotten, the
+	// others are kept when their offsets cannot be fetched.
+	listed := make(map[string]bool, len(groupIDs))
+	for _, groupID := range groupIDs {
+		listed[groupID] = true
+	}
+	e.groupMessages.prune(func(key offsetCounterKey) bool { return listed[key.group] })
+	if e.groupStatus != nil {
+		e.groupStatus.prune(func(key offsetCounterKey) bool { return listed[key.group] })
+	}
+	retry := e.collectCoordinatedGroups(ch, e.groupsByCoordinator(groupIDs, false), scrape)
+	if len(retry) > 0 {
+		glog.Infof("Looking up the coordinator of %d consumer groups again", len(retry))
+		for _, groupID := range e.collectCoordinatedGroups(ch, e.groupsByCoordinator(retry, true), scrape) {
+			e.scrapeErrorf(phaseGroups, "Cannot get metrics of group %s from its coordinator", groupID)
+		}
+	}
+
+	if e.committedTimestamps != nil {
+		e.collectCommittedTimestampMetrics(ch, scrape.commits)
+	}
+}
+
+// listConsumerGroups returns the consumer groups matching the group filter
+// and the groups targeted by name, and the number of groups that are
+// filtered out. Every broker only lists the groups it coordinates, so all of
+// them are asked.
+func (e *Exporter) listConsumerGroups() ([]string, int) {
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		groups = make(map[string]bool)
+	)
+	for _, broker := range e.client.Brokers() {
+		wg.Add(1)
+		go func(broker *sarama.Broker) {
+			defer wg.Done()
+			if err := broker.Open(e.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+				e.scrapeErrorf(phaseGroups, "Cannot connect to broker %d: %v", broker.ID(), err)
+				return
+			}
+			response, err := broker.ListGroups(&sarama.ListGroupsRequest{})
+			e.stats.request("ListGroups", broker, err)
+			if err != nil {
+				e.scrapeErrorf(phaseGroups, "Cannot get consumer group: %v", err)
+				// The client reopens the connection the next time it uses the broker.
+				_ = broker.Close()
+				return
+			}
+			if response.Err != sarama.ErrNoError {
+				e.scrapeErrorf(phaseGroups, "Cannot get consumer group from broker %d: %v", broker.ID(), response.Err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for groupID := range response.Groups {
+				groups[groupID] = e.groupFilter.MatchString(groupID)
+			}
+		}(broker)
+	}
+	wg.Wait()
+	// The groups targeted by name are collected whether they are listed and
+	// match the group filter or not.
+	for _, groupID := range e.groupNames {
+		groups[groupID] = true
+	}
+
+	groupIDs := make([]string, 0, len(groups))
+	for groupID, matches := range groups {
+		if matches {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	sort.Strings(groupIDs)
+	return groupIDs, len(groups) - len(groupIDs)
+}
+
+// groupsByCoordinator looks up the coordinator of the given groups, with up
+// to topic.workers lookups at once. When refresh is set, the coordinators
+// cached by the client are looked up again with FindCoordinator.
+func (e *Exporter) groupsByCoordinator(groupIDs []string, refresh bool) map[*sarama.Broker][]string {
+	var (
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		groupChannel = make(chan string)
+		coordinators = make(map[*sarama.Broker][]string)
+	)
+	workers := e.topicWorkers
+	if len(groupIDs) < workers {
+		workers = len(groupIDs)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for groupID := range groupChannel {
+				if refresh {
+					if err := e.client.RefreshCoordinator(groupID); err != nil {
+						e.scrapeErrorf(phaseGroups, "Cannot find coordinator of group %s: %v", groupID, err)
+						continue
+					}
+				}
+				broker, err := e.client.Coordinator(groupID)
+				if err != nil {
+					e.scrapeErrorf(phaseGroups, "Cannot find coordinator of group %s: %v", groupID, err)
+					continue
+				}
+				mu.Lock()
+				coordinators[broker] = append(coordinators[broker], groupID)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, groupID := range groupIDs {
+		groupChannel <- groupID
+	}
+	close(groupChannel)
+	wg.Wait()
+	return coordinators
+}
+
+// collectCoordinatedGroups describes the groups of every coordinator and
+// exports their metrics, the coordinators are queried concurrently. It
+// returns the groups that could not be described by the given coordinator,
+// because it moved or cannot be reached.
+func (e *Exporter) collectCoordinatedGroups(ch chan<- prometheus.Metric, coordinators map[*sarama.Broker][]string, scrape *groupScrape) []string {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		retry []string
+	)
+	for broker, groupIDs := range coordinators {
+		wg.Add(1)
+		go func(broker *sarama.Broker, groupIDs []string) {
+			defer wg.Done()
+			failed := e.collectGroupsOfCoordinator(ch, broker, groupIDs, scrape)
+			mu.Lock()
+			retry = append(retry, failed...)
+			mu.Unlock()
+		}(broker, groupIDs)
+	}
+	wg.Wait()
+	return retry
+}
+
+// collectGroupsOfCoordinator describes the given groups with a single
+// DescribeGroups request to their coordinator, fetches their offsets and
+// exports their metrics. It returns the groups the broker no longer
+// coordinates, or all of them when it cannot be reached, without exporting
+// any of their metrics.
+func (e *Exporter) collectGroupsOfCoordinator(ch chan<- prometheus.Metric, broker *sarama.Broker, groupIDs []string, scrape *groupScrape) []string {
+	if err := broker.Open(e.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+		e.scrapeErrorf(phaseGroups, "Cannot connect to broker %d: %v", broker.ID(), err)
+		return groupIDs
+	}
+	describeGroups, err := broker.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: groupIDs})
+	e.stats.request("DescribeGroups", broker, err)
+	if err != nil {
+		e.scrapeErrorf(phaseGroups, "Cannot describe groups of broker %d: %v", broker.ID(), err)
+		_ = broker.Close()
+		return groupIDs
+	}
+
+	var (
+		mu        sync.Mutex
+		retry     []string
+		described []describedGroup
+	)
+	for _, group := range describeGroups.Groups {
+		switch group.Err {
+		case sarama.ErrNoError:
+		case sarama.ErrNotCoordinatorForConsumer:
+			retry = append(retry, group.GroupId)
+			continue
+		default:
+			e.scrapeErrorf(phaseGroups, "Cannot describe group %s: %v", group.GroupId, group.Err)
+			continue
+		}
+		assignment := decodeGroupAssignment(group)
+		described = append(described, describedGroup{
+			group:      group,
+			assignment: assignment,
+			partitions: e.offsetFetchPartitions(assignment, scrape),
+		})
+	}
+
+	// Brokers supporting OffsetFetch v8 return the offsets of all the groups
+	// at once, older ones need a request per group.
+	var fetched map[string]groupOffsets
+	if e.supportsOffsetFetchGroups(broker) {
+		if fetched, err = e.fetchGroupsOffsets(broker, described); err != nil {
+			glog.Warningf("Cannot fetch the offsets of the groups of broker %d at once, fetching them one by one: %v", broker.ID(), err)
+		}
+	}
+	version := e.offsetFetchVersion(broker)
+
+	var (
+		wg     sync.WaitGroup
+		groups = make(chan describedGroup)
+	)
+	// The requests of the groups are sent concurrently up to the number of
+	// requests in flight sarama allows on the connection.
+	for i := 0; i < e.client.Config().Net.MaxOpenRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				offsets, ok := fetched[group.group.GroupId]
+				if !ok {
+					offsets = e.fetchGroupOffsets(broker, version, group.group.GroupId, group.partitions)
+				}
+				if offsets.err == sarama.ErrNotCoordinatorForConsumer {
+					// The coordinator moved since the group was described.
+					mu.Lock()
+					retry = append(retry, group.group.GroupId)
+					mu.Unlock()
+					continue
+				}
+				e.collectConsumerGroup(ch, broker, group.group, group.assignment, offsets, scrape)
+			}
+		}()
+	}
+	for _, group := range described {
+		groups <- group
+	}
+	close(groups)
+	wg.Wait()
+	return retry
+}
+
+// describedGroup is a group described by its coordinator, with the
+// partitions whose committed offsets are fetched.
+type describedGroup struct {
+	group      *sarama.GroupDescription
+	assignment groupAssignment
+	partitions map[string][]int32
+}
+
+// groupOffsets are the offsets committed by a consumer group, or the error
+// that prevented fetching them.
+type groupOffsets struct {
+	blocks map[string]map[int32]*sarama.OffsetFetchResponseBlock
+	err    error
+}
+
+// offsetFetchPartitions returns the partitions whose committed offsets are
+// fetched: the partitions of the collected topics with offset.show-all, the
+// partitions assigned to the members of the group otherwise.
+func (e *Exporter) offsetFetchPartitions(assignment groupAssignment, scrape *groupScrape) map[string][]int32 {
+	if e.offsetShowAll {
+		return scrape.topicsPartitions
+	}
+	partitions := make(map[string][]int32, len(assignment.partitions))
+	for topic, assigned := range assignment.partitions {
+		for partition := range assigned {
+			partitions[topic] = append(partitions[topic], partition)
+		}
+	}
+	return partitions
+}
+
+// fetchGroupOffsets fetches the offsets committed by a group on the given
+// partitions with an OffsetFetch request to its coordinator. Nothing is
+// fetched without partitions, since versions 2 and later would return the
+// offsets of every partition.
+func (e *Exporter) fetchGroupOffsets(broker *sarama.Broker, version int16, groupID string, partitions map[string][]int32) groupOffsets {
+	if len(partitions) == 0 {
+		return groupOffsets{}
+	}
+	request := sarama.OffsetFetchRequest{ConsumerGroup: groupID, Version: version}
+	for topic, topicPartitions := range partitions {
+		for _, partition := range topicPartitions {
+			request.AddPartition(topic, partition)
+		}
+	}
+	response, err := broker.FetchOffset(&request)
+	e.stats.request("OffsetFetch", broker, err)
+	switch {
+	case err != nil:
+		return groupOffsets{err: err}
+	case isNotCoordinator(response):
+		return groupOffsets{err: sarama.ErrNotCoordinatorForConsumer}
+	case response.Err != sarama.ErrNoError:
+		return groupOffsets{err: response.Err}
+	}
+	return groupOffsets{blocks: response.Blocks}
+}
+
+// fetchGroupsOffsets fetches the offsets committed by several groups with a
+// single OffsetFetch v8 request to their coordinator, and returns them by
+// group. The groups without partitions are not fetched. With
+// offset.show-all, all the offsets committed by the groups are fetched
+// rather than listing every partition, and those of the topics that are not
+// collected are dropped.
+func (e *Exporter) fetchGroupsOffsets(broker *sarama.Broker, groups []describedGroup) (map[string]groupOffsets, error) {
+	offsets := make(map[string]groupOffsets, len(groups))
+	request := make([]offsetFetchGroup, 0, len(groups))
+	for _, group := range groups {
+		if len(group.partitions) == 0 {
+			offsets[group.group.GroupId] = groupOffsets{}
+			continue
+		}
+		fetch := offsetFetchGroup{groupID: group.group.GroupId}
+		if !e.offsetShowAll {
+			fetch.topics = group.partitions
+		}
+		request = append(request, fetch)
+	}
+	if len(request) == 0 {
+		return offsets, nil
+	}
+
+	conn, err := dialBroker(broker.Addr(), e.client.Config())
+	if err != nil {
+		e.stats.request("OffsetFetch", broker, err)
+		return nil, err
+	}
+	defer conn.Close()
+	response, err := conn.roundTrip(apiKeyOffsetFetch, offsetFetchGroupsVersion, true, encodeOffsetFetchGroupsRequest(request))
+	var fetched map[string]groupOffsets
+	if err == nil {
+		fetched, err = decodeOffsetFetchGroupsResponse(response)
+	}
+	e.stats.request("OffsetFetch", broker, err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		if len(group.partitions) == 0 {
+			continue
+		}
+		groupID := group.group.GroupId
+		fetchedGroup, ok := fetched[groupID]
+		if !ok {
+			offsets[groupID] = groupOffsets{err: fmt.Errorf("missing from the response of broker %d", broker.ID())}
+			continue
+		}
+		for topic := range fetchedGroup.blocks {
+			if _, ok := group.partitions[topic]; !ok {
+				delete(fetchedGroup.blocks, topic)
+			}
+		}
+		offsets[groupID] = fetchedGroup
+	}
+	return offsets, nil
+}
+
+// collectConsumerGroup exports the membership of a group and its offsets and
+// lag, fetched from its coordinator.
+func (e *Exporter) collectConsumerGroup(ch chan<- prometheus.Metric, broker *sarama.Broker, group *sarama.GroupDescription, assignment groupAssignment, offsets groupOffsets, scrape *groupScrape) {
+	ch <- prometheus.MustNewConstMetric(
+		consumergroupCoordinator, prometheus.GaugeValue, 1, group.GroupId, strconv.Itoa(int(broker.ID())),
+	)
+	e.collectConsumerGroupMembership(ch, group, assignment)
+	ch <- prometheus.MustNewConstMetric(
+		consumergroupMembers, prometheus.GaugeValue, float64(len(group.Members)), group.GroupId,
+	)
+	if offsets.err != nil {
+		e.scrapeErrorf(phaseGroups, "Cannot get offset of group %s: %v", group.GroupId, offsets.err)
+		return
+	}
+	assigned := assignment.partitions
+
+	if e.offsetShowAll {
+		// Without offset.show-all only the assigned partitions are fetched.
+		unassigned := 0
+		for topic, partitions := range offsets.blocks {
+			for partition, block := range partitions {
+				if block.Err == sarama.ErrNoError && block.Offset != -1 && !assigned[topic][partition] {
+					unassigned++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupUnassignedPartitions, prometheus.GaugeValue, float64(unassigned), group.GroupId,
+		)
+	}
+
+	// The status of the group is the worst status of its partitions.
+	groupStatus := -1
+	defer func() {
+		if groupStatus < 0 {
+			return
+		}
+		for i, status := range consumerGroupStatuses {
+			value := 0
+			if i == groupStatus {
+				value = 1
+			}
+			ch <- prometheus.MustNewConstMetric(
+				consumergroupStatus, prometheus.GaugeValue, float64(value), group.GroupId, status,
+			)
+		}
+	}()
+
+	for topic, partitions := range offsets.blocks {
+		// If the topic is not consumed by that consumer group, skip it
+		topicConsumed := false
+		for _, offsetFetchResponseBlock := range partitions {
+			// 如果消费者组下没有与topic partition 关联的偏移量，Kafka将返回-1
+			// Kafka will return -1 if there is no offset associated with a topic-partition under that consumer group
+			if offsetFetchResponseBlock.Offset != -1 {
+				topicConsumed = true
+				break
+			}
+		}
+		// 如果有未关联的topic与partition，跳出此次循环
+		if !topicConsumed {
+			continue
+		}
+		var currentOffsetSum int64
+		var lagSum int64
+		for partition, offsetFetchResponseBlock := range partitions {
+			err := offsetFetchResponseBlock.Err
+			if err != sarama.ErrNoError {
+				e.scrapeErrorf(phaseGroups, "Error for  partition %d :%v", partition, err.Error())
+				continue
+			}
+			// 获取当前分区的消费位移
+			currentOffset := offsetFetchResponseBlock.Offset
+			// 所有分区的消费偏移量加起来
+			if currentOffset != -1 {
+				currentOffsetSum += currentOffset
+			}
+			ch <- prometheus.MustNewConstMetric(
+				consumergroupCurrentOffset, prometheus.GaugeValue, float64(currentOffset), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
+			)
+			if currentOffset != -1 {
+				messages := e.groupMessages.observe(offsetCounterKey{group: group.GroupId, topic: topic, partition: partition}, currentOffset)
+				ch <- prometheus.MustNewConstMetric(
+					consumergroupMessagesConsumed, prometheus.CounterValue, float64(messages), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
+				)
+			}
+
+			// Reuse the newest offsets fetched with the topic metrics, topics
+			// outside of the topic filter are only listed without offset.show-all
+			currentOffset, ok := scrape.offsets[topic][partition]
+			if !ok {
+				newestOffset, err := e.client.GetOffset(topic, partition, sarama.OffsetNewest)
+				if err != nil {
+					e.scrapeErrorf(phaseGroups, "Cannot get current offset of topic %s partition %d: %v", topic, partition, err)
+					continue
+				}
+				currentOffset = newestOffset
+			}
+
+			// If the topic is consumed by that consumer group, but no offset associated with the partition
+			// forcing lag to -1 to be able to alert on that
+			var lag int64
+			if offsetFetchResponseBlock.Offset == -1 {
+				lag = -1
+			} else {
+				// 积压=生产位移-消费位移
+				lag = currentOffset - offsetFetchResponseBlock.Offset
+				lagSum += lag
+			}
+			ch <- prometheus.MustNewConstMetric(
+				consumergroupLag, prometheus.GaugeValue, float64(lag), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
+			)
+			if e.offsetHistory != nil && offsetFetchResponseBlock.Offset != -1 {
+				if lagSeconds, ok := e.offsetHistory.lagSeconds(topic, partition, offsetFetchResponseBlock.Offset, time.Now()); ok {
+					ch <- prometheus.MustNewConstMetric(
+						consumergroupLagSeconds, prometheus.GaugeValue, lagSeconds, group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
+					)
+				}
+			}
+			if e.groupStatus != nil && offsetFetchResponseBlock.Offset != -1 {
+				key := offsetCounterKey{group: group.GroupId, topic: topic, partition: partition}
+				status := e.groupStatus.observe(key, offsetFetchResponseBlock.Offset, lag, len(group.Members) > 0)
+				if status > groupStatus {
+					groupStatus = status
+				}
+				ch <- prometheus.MustNewConstMetric(
+					consumergroupPartitionStatus, prometheus.GaugeValue, float64(status), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
+				)
+			}
+			if e.committedTimestamps != nil && lag > 0 {
+				// Without lag there is no record at the committed offset yet.
+				scrape.commitsMu.Lock()
+				scrape.commits = append(scrape.commits, groupCommit{
+					group:           group.GroupId,
+					committedOffset: committedOffset{topic: topic, partition: partition, offset: offsetFetchResponseBlock.Offset},
+				})
+				scrape.commitsMu.Unlock()
+			}
+
+		}
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupCurrentOffsetSum, prometheus.GaugeValue, float64(currentOffsetSum), group.GroupId, topic,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupLagSum, prometheus.GaugeValue, float64(lagSum), group.GroupId, topic,
+		)
+		consumeRate, ok := e.groupRates.observe(rateKey{group: group.GroupId, topic: topic}, currentOffsetSum, time.Now())
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupConsumeRate, prometheus.GaugeValue, consumeRate, group.GroupId, topic,
+		)
+		if produceRate, ok := scrape.produceRates[topic]; ok {
+			drainSeconds := math.Inf(1)
+			if lagSum == 0 {
+				drainSeconds = 0
+			} else if consumeRate > produceRate {
+				drainSeconds = float64(lagSum) / (consumeRate - produceRate)
+			}
+			ch <- prometheus.MustNewConstMetric(
+				consumergroupLagDrainSeconds, prometheus.GaugeValue, drainSeconds, group.GroupId, topic,
+			)
+		}
+	}
+}
+
+// isNotCoordinator returns whether the broker answering an OffsetFetch
+// request is no longer the coordinator of the group. Versions 2 and later
+// report it for the whole response, older ones for every partition.
+func isNotCoordinator(response *sarama.OffsetFetchResponse) bool {
+	if response.Err == sarama.ErrNotCoordinatorForConsumer {
+		return true
+	}
+	for _, partitions := range response.Blocks {
+		for _, block := range partitions {
+			if block.Err == sarama.ErrNotCoordinatorForConsumer {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memberKey identifies the consumers of a group by client, several members
+// of the same client are counted together.
+type memberKey struct {
+	clientID   string
+	clientHost string
+}
+
+// groupAssignment is the assignment of the partitions of a consumer group to
+// its members.
+type groupAssignment struct {
+	// The partitions assigned to any member.
+	partitions map[string]map[int32]bool
+	// The number of partitions assigned to the members of each client.
+	memberPartitions map[memberKey]int
+	// The members whose assignment cannot be decoded.
+	errs []error
+}
+
+// decodeGroupAssignment decodes the assignments of the members of a group.
+func decodeGroupAssignment(group *sarama.GroupDescription) groupAssignment {
+	assignment := groupAssignment{
+		partitions:       make(map[string]map[int32]bool),
+		memberPartitions: make(map[memberKey]int, len(group.Members)),
+	}
+	for memberID, member := range group.Members {
+		key := memberKey{clientID: member.ClientId, clientHost: member.ClientHost}
+		if _, ok := assignment.memberPartitions[key]; !ok {
+			assignment.memberPartitions[key] = 0
+		}
+		if len(member.MemberAssignment) == 0 {
+			// The members of a rebalancing group have no assignment yet.
+			continue
+		}
+		memberAssignment, err := member.GetMemberAssignment()
+		if err != nil {
+			assignment.errs = append(assignment.errs, fmt.Errorf("member %s: %v", memberID, err))
+			continue
+		}
+		for topic, partitions := range memberAssignment.Topics {
+			if _, ok := assignment.partitions[topic]; !ok {
+				assignment.partitions[topic] = make(map[int32]bool, len(partitions))
+			}
+			for _, partition := range partitions {
+				assignment.partitions[topic][partition] = true
+			}
+			assignment.memberPartitions[key] += len(partitions)
+		}
+	}
+	return assignment
+}
+
+// collectConsumerGroupMembership exports the state, protocol and member
+// assignments of a consumer group.
+func (e *Exporter) collectConsumerGroupMembership(ch chan<- prometheus.Metric, group *sarama.GroupDescription, assignment groupAssignment) {
+	state := group.State
+	if state == "AwaitingSync" {
+		state = "CompletingRebalance"
+	}
+	known := false
+	for _, s := range consumerGroupStates {
+		value := 0
+		if s == state {
+			value = 1
+			known = true
+		}
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupState, prometheus.GaugeValue, float64(value), group.GroupId, s,
+		)
+	}
+	if !known {
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupState, prometheus.GaugeValue, 1, group.GroupId, state,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		consumergroupInfo, prometheus.GaugeValue, 1, group.GroupId, group.ProtocolType, group.Protocol,
+	)
+
+	for _, err := range assignment.errs {
+		e.scrapeErrorf(phaseGroups, "Cannot get assignment of %v of group %s", err, group.GroupId)
+	}
+	for key, partitions := range assignment.memberPartitions {
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupMemberPartitions, prometheus.GaugeValue, float64(partitions), group.GroupId, key.clientID, key.clientHost,
+		)
+	}
+}
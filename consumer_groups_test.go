@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGroupsByCoordinator(t *testing.T) {
+	seed := sarama.NewMockBroker(t, 1)
+	defer seed.Close()
+	first := sarama.NewMockBroker(t, 2)
+	defer first.Close()
+	second := sarama.NewMockBroker(t, 3)
+	defer second.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(first.Addr(), first.BrokerID()).
+		SetBroker(second.Addr(), second.BrokerID())
+	coordinators := sarama.NewMockFindCoordinatorResponse(t).
+		SetCoordinator(sarama.CoordinatorGroup, "orders", first).
+		SetCoordinator(sarama.CoordinatorGroup, "payments", second)
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+	})
+	first.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+		"ListGroupsRequest":      sarama.NewMockListGroupsResponse(t).AddGroup("orders", "consumer"),
+	})
+	second.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+			AddGroup("payments", "consumer").
+			AddGroup("console-consumer-1", "consumer"),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{seed.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	e := &Exporter{client: client, groupFilter: regexp.MustCompile("^(orders|payments)$"), topicWorkers: 10}
+	groupIDs, filtered := e.listConsumerGroups()
+	if !reflect.DeepEqual(groupIDs, []string{"orders", "payments"}) || filtered != 1 {
+		t.Fatalf("unexpected groups %v, %d filtered", groupIDs, filtered)
+	}
+	for _, id := range []int32{2, 3} {
+		if count := e.stats.requests[requestKey{api: "ListGroups", broker: id}]; count == nil || count.total != 1 || count.errors != 0 {
+			t.Errorf("unexpected ListGroups requests to broker %d: %+v", id, count)
+		}
+	}
+
+	byBroker := func(refresh bool) map[int32][]string {
+		ids := make(map[int32][]string)
+		for broker, groups := range e.groupsByCoordinator(groupIDs, refresh) {
+			ids[broker.ID()] = groups
+		}
+		return ids
+	}
+	if got := byBroker(false); !reflect.DeepEqual(got, map[int32][]string{2: {"orders"}, 3: {"payments"}}) {
+		t.Errorf("unexpected coordinators %v", got)
+	}
+
+	// The coordinator of orders fails over, the cached one is used until the
+	// coordinators are refreshed.
+	coordinators.SetCoordinator(sarama.CoordinatorGroup, "orders", second)
+	if got := byBroker(false); !reflect.DeepEqual(got, map[int32][]string{2: {"orders"}, 3: {"payments"}}) {
+		t.Errorf("unexpected cached coordinators %v", got)
+	}
+	if got := byBroker(true); len(got) != 1 || len(got[3]) != 2 {
+		t.Errorf("unexpected refreshed coordinators %v", got)
+	}
+}
+
+// groupTestDescs creates the descs of the consumer group metrics and returns
+// their names.
+func groupTestDescs() map[*prometheus.Desc]string {
+	exporterFilteredGroups = testDesc("filtered")
+	consumergroupCoordinator = testDesc("coordinator", "consumergroup", "broker")
+	consumergroupState = testDesc("state", "consumergroup", "state")
+	consumergroupInfo = testDesc("info", "consumergroup", "protocol_type", "protocol")
+	consumergroupMemberPartitions = testDesc("member_partitions", "consumergroup", "client_id", "client_host")
+	consumergroupMembers = testDesc("members", "consumergroup")
+	consumergroupUnassignedPartitions = testDesc("unassigned", "consumergroup")
+	consumergroupCurrentOffset = testDesc("offset", "consumergroup", "topic", "partition")
+	consumergroupMessagesConsumed = testDesc("consumed", "consumergroup", "topic", "partition")
+	consumergroupLag = testDesc("lag", "consumergroup", "topic", "partition")
+	consumergroupCurrentOffsetSum = testDesc("offset_sum", "consumergroup", "topic")
+	consumergroupLagSum = testDesc("lag_sum", "consumergroup", "topic")
+	return map[*prometheus.Desc]string{
+		exporterFilteredGroups:            "filtered",
+		consumergroupCoordinator:          "coordinator",
+		consumergroupState:                "state",
+		consumergroupInfo:                 "info",
+		consumergroupMemberPartitions:     "member_partitions",
+		consumergroupMembers:              "members",
+		consumergroupUnassignedPartitions: "unassigned",
+		consumergroupCurrentOffset:        "offset",
+		consumergroupMessagesConsumed:     "consumed",
+		consumergroupLag:                  "lag",
+		consumergroupCurrentOffsetSum:     "offset_sum",
+		consumergroupLagSum:               "lag_sum",
+	}
+}
+
+func TestCollectConsumerGroupMetricsRetriesMovedGroups(t *testing.T) {
+	seed := sarama.NewMockBroker(t, 1)
+	defer seed.Close()
+	first := sarama.NewMockBroker(t, 2)
+	defer first.Close()
+	second := sarama.NewMockBroker(t, 3)
+	defer second.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(first.Addr(), first.BrokerID()).
+		SetBroker(second.Addr(), second.BrokerID())
+	coordinators := sarama.NewMockFindCoordinatorResponse(t).
+		SetCoordinator(sarama.CoordinatorGroup, "orders", first).
+		SetCoordinator(sarama.CoordinatorGroup, "billing", second)
+	apiVersions := sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+		ApiVersions: []*sarama.ApiVersionsResponseBlock{{ApiKey: apiKeyOffsetFetch, MaxVersion: 4}},
+	})
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+	})
+	// The first broker describes orders, but no longer coordinates it when
+	// its offsets are fetched.
+	first.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+		"ApiVersionsRequest":     apiVersions,
+		"ListGroupsRequest":      sarama.NewMockListGroupsResponse(t).AddGroup("orders", "consumer"),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("orders", &sarama.GroupDescription{GroupId: "orders", State: "Empty", ProtocolType: "consumer"}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).SetError(sarama.ErrNotCoordinatorForConsumer),
+	})
+	// The second broker coordinates billing, which no broker lists.
+	second.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":        metadata,
+		"FindCoordinatorRequest": coordinators,
+		"ApiVersionsRequest":     apiVersions,
+		"ListGroupsRequest":      sarama.NewMockListGroupsResponse(t),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("orders", &sarama.GroupDescription{GroupId: "orders", State: "Empty", ProtocolType: "consumer"}).
+			AddGroupDescription("billing", &sarama.GroupDescription{GroupId: "billing", State: "Empty", ProtocolType: "consumer"}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("orders", "orders", 0, 5, "", sarama.ErrNoError).
+			SetOffset("billing", "orders", 0, 7, "", sarama.ErrNoError),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{seed.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// The client caches the first coordinator of orders before it fails over.
+	if _, err := client.Coordinator("orders"); err != nil {
+		t.Fatal(err)
+	}
+	coordinators.SetCoordinator(sarama.CoordinatorGroup, "orders", second)
+
+	e := &Exporter{
+		client:        client,
+		groupFilter:   regexp.MustCompile("^orders$"),
+		groupNames:    []string{"billing"},
+		topicWorkers:  10,
+		offsetShowAll: true,
+		groupMessages: newOffsetCounters(),
+		groupRates:    newRateTracker(),
+		apiVersions:   newAPIVersionCache(),
+	}
+	metrics := collectTestMetrics(t, groupTestDescs(), func(ch chan<- prometheus.Metric) {
+		e.collectConsumerGroupMetrics(ch, &groupScrape{
+			topicsPartitions: map[string][]int32{"orders": {0}},
+			offsets:          map[string]map[int32]int64{"orders": {0: 10}},
+		})
+	})
+
+	for key, expected := range map[string]float64{
+		`coordinator{broker="3",consumergroup="orders"}`:            1,
+		`coordinator{broker="3",consumergroup="billing"}`:           1,
+		`lag{consumergroup="orders",partition="0",topic="orders"}`:  5,
+		`lag{consumergroup="billing",partition="0",topic="orders"}`: 3,
+		`state{consumergroup="orders",state="Empty"}`:               1,
+		`unassigned{consumergroup="orders"}`:                        1,
+		`filtered{}`:                                                0,
+	} {
+		if value, ok := metrics[key]; !ok || value != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", key, expected, value, ok)
+		}
+	}
+	if _, ok := metrics[`coordinator{broker="2",consumergroup="orders"}`]; ok {
+		t.Error("expected no metric of orders from its former coordinator")
+	}
+	if errors := e.stats.errors[phaseGroups]; errors != 0 {
+		t.Errorf("expected the moved group to be retried without error, got %v errors", errors)
+	}
+}
+
+// encodeMemberAssignment encodes the assignment of a consumer to the given
+// partitions of a topic.
+func encodeMemberAssignment(topic string, partitions ...int32) []byte {
+	var b bytes.Buffer
+	write := func(v interface{}) { _ = binary.Write(&b, binary.BigEndian, v) }
+	write(int16(0)) // version
+	write(int32(1)) // topics
+	write(int16(len(topic)))
+	b.WriteString(topic)
+	write(int32(len(partitions)))
+	write(partitions)
+	write(int32(-1)) // user data
+	return b.Bytes()
+}
+
+func TestCollectConsumerGroupMetricsSkipsGroupsWithoutPartitions(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	metadata := sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "orders", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "idle", broker),
+		"ApiVersionsRequest": sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+			ApiVersions: []*sarama.ApiVersionsResponseBlock{{ApiKey: apiKeyOffsetFetch, MaxVersion: 4}},
+		}),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t).
+			AddGroup("orders", "consumer").
+			AddGroup("idle", "consumer"),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("orders", &sarama.GroupDescription{
+				GroupId:      "orders",
+				State:        "Stable",
+				ProtocolType: "consumer",
+				Members: map[string]*sarama.GroupMemberDescription{
+					"orders-1": {ClientId: "orders", ClientHost: "/10.0.0.1", MemberAssignment: encodeMemberAssignment("orders", 0)},
+				},
+			}).
+			// Without members nothing is assigned, and no offset is
+			// fetched without offset.show-all.
+			AddGroupDescription("idle", &sarama.GroupDescription{GroupId: "idle", State: "Empty", ProtocolType: "consumer"}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("orders", "orders", 0, 5, "", sarama.ErrNoError).
+			SetOffset("idle", "orders", 0, 2, "", sarama.ErrNoError),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	e := &Exporter{
+		client:        client,
+		groupFilter:   regexp.MustCompile(".*"),
+		topicWorkers:  10,
+		groupMessages: newOffsetCounters(),
+		groupRates:    newRateTracker(),
+		apiVersions:   newAPIVersionCache(),
+	}
+	metrics := collectTestMetrics(t, groupTestDescs(), func(ch chan<- prometheus.Metric) {
+		e.collectConsumerGroupMetrics(ch, &groupScrape{
+			topicsPartitions: map[string][]int32{"orders": {0}},
+			offsets:          map[string]map[int32]int64{"orders": {0: 10}},
+		})
+	})
+
+	if lag := metrics[`lag{consumergroup="orders",partition="0",topic="orders"}`]; lag != 5 {
+		t.Errorf("expected a lag of 5, got %v", lag)
+	}
+	if _, ok := metrics[`lag{consumergroup="idle",partition="0",topic="orders"}`]; ok {
+		t.Error("expected no offset of the Empty group")
+	}
+	if _, ok := metrics[`state{consumergroup="idle",state="Empty"}`]; !ok {
+		t.Error("expected the state of the Empty group")
+	}
+
+	var fetched []string
+	for _, exchange := range broker.History() {
+		if request, ok := exchange.Request.(*sarama.OffsetFetchRequest); ok {
+			fetched = append(fetched, request.ConsumerGroup)
+		}
+	}
+	if expected := []string{"orders"}; !reflect.DeepEqual(fetched, expected) {
+		t.Errorf("expected the OffsetFetch requests of %v, got %v", expected, fetched)
+	}
+}
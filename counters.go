@@ -0,0 +1,60 @@
+package main
+
+import "sync"
+
+// offsetCounterKey identifies a partition when group is empty, the committed
+// offsets of a consumer group on a partition otherwise.
+type offsetCounterKey struct {
+	group     string
+	topic     string
+	partition int32
+}
+
+type offsetCounter struct {
+	offset int64
+	total  int64
+}
+
+// offsetCounters turns the successive offsets of partitions into counters of
+// the messages produced or consumed since the exporter started, which are not
+// affected by the offsets going backwards.
+type offsetCounters struct {
+	mu       sync.Mutex
+	counters map[offsetCounterKey]*offsetCounter
+}
+
+func newOffsetCounters() *offsetCounters {
+	return &offsetCounters{counters: make(map[offsetCounterKey]*offsetCounter)}
+}
+
+// observe records the offset and returns the number of messages counted so
+// far. A counter starts at 0 on its first observation. When the offset went
+// backwards, e.g. because the partition was recreated or the group reset its
+// offsets, the counter continues from the new offset.
+func (c *offsetCounters) observe(key offsetCounterKey, offset int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &offsetCounter{offset: offset}
+		c.counters[key] = counter
+	}
+	if offset > counter.offset {
+		counter.total += offset - counter.offset
+	}
+	counter.offset = offset
+	return counter.total
+}
+
+// prune forgets the counters for which keep returns false.
+func (c *offsetCounters) prune(keep func(offsetCounterKey) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.counters {
+		if !keep(key) {
+			delete(c.counters, key)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestOffsetCountersObserve(t *testing.T) {
+	c := newOffsetCounters()
+	key := offsetCounterKey{topic: "orders", partition: 0}
+	for _, tc := range []struct {
+		offset   int64
+		expected int64
+	}{
+		{offset: 1000, expected: 0},
+		{offset: 1500, expected: 500},
+		{offset: 1500, expected: 500},
+		// The partition was recreated.
+		{offset: 20, expected: 500},
+		{offset: 100, expected: 580},
+	} {
+		if total := c.observe(key, tc.offset); total != tc.expected {
+			t.Errorf("offset %d: expected %d messages, got %d", tc.offset, tc.expected, total)
+		}
+	}
+
+	c.prune(func(offsetCounterKey) bool { return false })
+	if total := c.observe(key, 200); total != 0 {
+		t.Errorf("expected pruned counter to restart, got %d", total)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import "sync"
+
+// consumerGroupStatuses are the statuses of the consumer groups and their
+// partitions, by increasing severity.
+var consumerGroupStatuses = []string{"OK", "WARN", "STALL", "STOP", "ERR"}
+
+// The indexes of the statuses in consumerGroupStatuses, exported as the
+// value of the partition status.
+const (
+	groupStatusOK = iota
+	groupStatusWarn
+	groupStatusStall
+	groupStatusStop
+	groupStatusErr
+)
+
+type groupStatusSample struct {
+	offset int64
+	lag    int64
+}
+
+// groupStatusWindow is a ring of the last samples of a partition.
+type groupStatusWindow struct {
+	samples []groupStatusSample
+	next    int
+	full    bool
+}
+
+func (w *groupStatusWindow) add(sample groupStatusSample) {
+	w.samples[w.next] = sample
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// ordered returns the samples from the oldest to the newest.
+func (w *groupStatusWindow) ordered() []groupStatusSample {
+	if !w.full {
+		return w.samples[:w.next]
+	}
+	return append(append([]groupStatusSample(nil), w.samples[w.next:]...), w.samples[:w.next]...)
+}
+
+// groupStatusTracker evaluates the status of the partitions consumed by the
+// consumer groups from a sliding window of their committed offsets and lag,
+// sampled at every scrape, following the rules of Burrow.
+type groupStatusTracker struct {
+	size    int
+	mu      sync.Mutex
+	windows map[offsetCounterKey]*groupStatusWindow
+}
+
+func newGroupStatusTracker(size int) *groupStatusTracker {
+	return &groupStatusTracker{
+		size:    size,
+		windows: make(map[offsetCounterKey]*groupStatusWindow),
+	}
+}
+
+// observe adds the committed offset and lag of a group on a partition to its
+// window and returns the status of the partition. active tells whether the
+// group has members. Partitions are OK until their window is full.
+func (t *groupStatusTracker) observe(key offsetCounterKey, offset int64, lag int64, active bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	window, ok := t.windows[key]
+	if !ok {
+		window = &groupStatusWindow{samples: make([]groupStatusSample, t.size)}
+		t.windows[key] = window
+	}
+	window.add(groupStatusSample{offset: offset, lag: lag})
+	if !window.full {
+		return groupStatusOK
+	}
+	return evaluateGroupStatus(window.ordered(), active)
+}
+
+// prune forgets the windows for which keep returns false.
+func (t *groupStatusTracker) prune(keep func(offsetCounterKey) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.windows {
+		if !keep(key) {
+			delete(t.windows, key)
+		}
+	}
+}
+
+// evaluateGroupStatus returns the status of a partition from a full window
+// of samples:
+//   - OK when the lag was zero at any time in the window,
+//   - ERR when the committed offset went backwards,
+//   - STOP when the committed offset did not move and the group has no
+//     members, STALL when it has some,
+//   - WARN when the committed offset moved but the lag never decreased,
+//   - OK otherwise.
+func evaluateGroupStatus(samples []groupStatusSample, active bool) int {
+	for _, sample := range samples {
+		if sample.lag == 0 {
+			return groupStatusOK
+		}
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].offset < samples[i-1].offset {
+			return groupStatusErr
+		}
+	}
+	if samples[0].offset == samples[len(samples)-1].offset {
+		if !active {
+			return groupStatusStop
+		}
+		return groupStatusStall
+	}
+	for i := 1; i < len(samples); i++ {
+		if samples[i].lag < samples[i-1].lag {
+			return groupStatusOK
+		}
+	}
+	return groupStatusWarn
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestEvaluateGroupStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		samples  []groupStatusSample
+		active   bool
+		expected int
+	}{
+		{
+			name:     "caught up once",
+			samples:  []groupStatusSample{{offset: 10, lag: 5}, {offset: 15, lag: 0}, {offset: 15, lag: 8}},
+			active:   true,
+			expected: groupStatusOK,
+		},
+		{
+			name:     "catching up",
+			samples:  []groupStatusSample{{offset: 10, lag: 50}, {offset: 30, lag: 60}, {offset: 80, lag: 20}},
+			active:   true,
+			expected: groupStatusOK,
+		},
+		{
+			name:     "slow",
+			samples:  []groupStatusSample{{offset: 10, lag: 50}, {offset: 20, lag: 50}, {offset: 30, lag: 70}},
+			active:   true,
+			expected: groupStatusWarn,
+		},
+		{
+			name:     "stuck",
+			samples:  []groupStatusSample{{offset: 10, lag: 50}, {offset: 10, lag: 60}, {offset: 10, lag: 60}},
+			active:   true,
+			expected: groupStatusStall,
+		},
+		{
+			name:     "stopped",
+			samples:  []groupStatusSample{{offset: 10, lag: 50}, {offset: 10, lag: 60}, {offset: 10, lag: 60}},
+			active:   false,
+			expected: groupStatusStop,
+		},
+		{
+			name:     "rewound",
+			samples:  []groupStatusSample{{offset: 10, lag: 50}, {offset: 5, lag: 60}, {offset: 8, lag: 60}},
+			active:   true,
+			expected: groupStatusErr,
+		},
+	} {
+		if status := evaluateGroupStatus(tc.samples, tc.active); status != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, consumerGroupStatuses[tc.expected], consumerGroupStatuses[status])
+		}
+	}
+}
+
+func TestGroupStatusTrackerWindow(t *testing.T) {
+	tracker := newGroupStatusTracker(3)
+	key := offsetCounterKey{group: "orders-processor", topic: "orders", partition: 0}
+	// The partition is OK until its window is full, then the oldest samples
+	// leave the window.
+	for i, expected := range []int{groupStatusOK, groupStatusOK, groupStatusStall, groupStatusStall} {
+		if status := tracker.observe(key, 10, 50, true); status != expected {
+			t.Errorf("sample %d: expected %s, got %s", i, consumerGroupStatuses[expected], consumerGroupStatuses[status])
+		}
+	}
+	if status := tracker.observe(key, 20, 40, true); status != groupStatusOK {
+		t.Errorf("expected OK once the offset moves, got %s", consumerGroupStatuses[status])
+	}
+}
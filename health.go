@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+const (
+	healthOK     = "ok"
+	healthFailed = "failed"
+)
+
+// healthCheck is the result of one of the checks of the health endpoints.
+type healthCheck struct {
+	Name    string `json:"name"`
+	Cluster string `json:"cluster,omitempty"`
+	Status  string `json:"status"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// healthReport is the JSON body of the health endpoints. It is ok when all
+// its checks are.
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+func newHealthReport(checks []healthCheck) healthReport {
+	report := healthReport{Status: healthOK, Checks: checks}
+	if report.Checks == nil {
+		report.Checks = []healthCheck{}
+	}
+	for _, check := range checks {
+		if check.Status != healthOK {
+			report.Status = healthFailed
+		}
+	}
+	return report
+}
+
+// ServeHTTP writes the report, with a 503 status when it failed so that the
+// probes of Kubernetes fail too.
+func (r healthReport) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		glog.Errorf("Cannot write health report: %v", err)
+	}
+}
+
+func passed(name string, format string, args ...interface{}) healthCheck {
+	return healthCheck{Name: name, Status: healthOK, Detail: fmt.Sprintf(format, args...)}
+}
+
+func failed(name string, format string, args ...interface{}) healthCheck {
+	return healthCheck{Name: name, Status: healthFailed, Detail: fmt.Sprintf(format, args...)}
+}
+
+// healthState tracks the scrapes in progress and the metadata refreshes of an
+// Exporter. The zero value is ready to use.
+type healthState struct {
+	mu                  sync.Mutex
+	nextScrape          int
+	scrapes             map[int]time.Time
+	lastMetadataRefresh time.Time
+	metadataErr         error
+}
+
+// scrapeStarted records a scrape started at start, and returns the id to
+// give to scrapeDone.
+func (s *healthState) scrapeStarted(start time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scrapes == nil {
+		s.scrapes = make(map[int]time.Time)
+	}
+	s.nextScrape++
+	s.scrapes[s.nextScrape] = start
+	return s.nextScrape
+}
+
+func (s *healthState) scrapeDone(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scrapes, id)
+}
+
+// oldestScrape returns the start of the oldest scrape in progress.
+func (s *healthState) oldestScrape() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest time.Time
+	for _, start := range s.scrapes {
+		if oldest.IsZero() || start.Before(oldest) {
+			oldest = start
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// metadataRefreshed records a metadata refresh at the given time, which
+// failed when err is not nil.
+func (s *healthState) metadataRefreshed(at time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metadataErr = err
+	if err == nil {
+		s.lastMetadataRefresh = at
+	}
+}
+
+func (s *healthState) metadata() (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastMetadataRefresh, s.metadataErr
+}
+
+// livenessChecks checks that no scrape has been running for longer than the
+// maximum scrape duration, which would mean the exporter is stuck.
+func (e *Exporter) livenessChecks(now time.Time) []healthCheck {
+	start, running := e.health.oldestScrape()
+	switch {
+	case !running:
+		return []healthCheck{passed("scrape", "no scrape in progress")}
+	case e.maxScrapeDuration > 0 && now.Sub(start) > e.maxScrapeDuration:
+		return []healthCheck{failed("scrape", "scrape in progress for %v, more than %v", now.Sub(start).Round(time.Second), e.maxScrapeDuration)}
+	default:
+		return []healthCheck{passed("scrape", "scrape in progress for %v", now.Sub(start).Round(time.Second))}
+	}
+}
+
+// readinessChecks checks that a broker answers, over an authenticated
+// connection when SASL is enabled, and that the metadata was refreshed
+// recently. The metadata is refreshed first when it is due, as the scrapes
+// would do.
+func (e *Exporter) readinessChecks(now time.Time) []healthCheck {
+	// The failure, if any, is reported by the metadata check.
+	_ = e.refreshMetadata(now)
+
+	brokers := e.client.Brokers()
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+
+	// Stop at the first broker that answers, one is enough to scrape.
+	var reachable *sarama.Broker
+	var errs []string
+	var saslErr error
+	for _, broker := range brokers {
+		err := e.checkBroker(broker)
+		if err == nil {
+			reachable = broker
+			break
+		}
+		errs = append(errs, fmt.Sprintf("broker %d: %v", broker.ID(), err))
+		if saslErr == nil && isSASLError(err) {
+			saslErr = err
+		}
+	}
+
+	var checks []healthCheck
+	switch {
+	case reachable != nil:
+		checks = append(checks, passed("brokers", "broker %d is reachable", reachable.ID()))
+	case len(brokers) == 0:
+		checks = append(checks, failed("brokers", "no broker in the metadata"))
+	default:
+		checks = append(checks, failed("brokers", "no broker is reachable: %s", strings.Join(errs, "; ")))
+	}
+
+	last, err := e.health.metadata()
+	age := now.Sub(last).Round(time.Second)
+	switch {
+	case e.metadataMaxAge <= 0:
+		checks = append(checks, passed("metadata", "refreshed %v ago", age))
+	case now.Sub(last) > e.metadataMaxAge && err != nil:
+		checks = append(checks, failed("metadata", "not refreshed for %v: %v", age, err))
+	case now.Sub(last) > e.metadataMaxAge:
+		checks = append(checks, failed("metadata", "not refreshed for %v, more than %v", age, e.metadataMaxAge))
+	default:
+		checks = append(checks, passed("metadata", "refreshed %v ago", age))
+	}
+
+	sasl := e.client.Config().Net.SASL
+	switch {
+	case !sasl.Enable:
+		checks = append(checks, passed("sasl", "SASL is disabled"))
+	case reachable != nil:
+		checks = append(checks, passed("sasl", "authenticated to broker %d with %s", reachable.ID(), sasl.Mechanism))
+	case saslErr != nil:
+		checks = append(checks, failed("sasl", "authentication with %s failed: %v", sasl.Mechanism, saslErr))
+	default:
+		checks = append(checks, failed("sasl", "no broker is reachable to authenticate to"))
+	}
+	return checks
+}
+
+// isSASLError tells whether err is an authentication failure returned by
+// the broker, the other errors of the broker checks being connection
+// failures.
+func isSASLError(err error) bool {
+	var kerr sarama.KError
+	if !errors.As(err, &kerr) {
+		return false
+	}
+	switch kerr {
+	case sarama.ErrSASLAuthenticationFailed, sarama.ErrUnsupportedSASLMechanism, sarama.ErrIllegalSASLState:
+		return true
+	}
+	return false
+}
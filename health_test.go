@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestHealthReport(t *testing.T) {
+	for _, test := range []struct {
+		checks   []healthCheck
+		code     int
+		expected string
+	}{
+		{nil, http.StatusOK, healthOK},
+		{[]healthCheck{passed("scrape", "no scrape in progress")}, http.StatusOK, healthOK},
+		{[]healthCheck{passed("brokers", "broker 1 is reachable"), failed("metadata", "not refreshed")}, http.StatusServiceUnavailable, healthFailed},
+	} {
+		w := httptest.NewRecorder()
+		newHealthReport(test.checks).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var report healthReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != test.code || report.Status != test.expected || len(report.Checks) != len(test.checks) {
+			t.Errorf("%v: expected status %d %s, got %d %s", test.checks, test.code, test.expected, w.Code, w.Body)
+		}
+	}
+}
+
+func TestLivenessChecks(t *testing.T) {
+	now := time.Now()
+	e := &Exporter{maxScrapeDuration: time.Minute}
+	if check := e.livenessChecks(now)[0]; check.Status != healthOK {
+		t.Errorf("expected no scrape to be ok, got %+v", check)
+	}
+
+	stuck := e.health.scrapeStarted(now.Add(-2 * time.Minute))
+	e.health.scrapeStarted(now.Add(-time.Second))
+	if check := e.livenessChecks(now)[0]; check.Status != healthFailed {
+		t.Errorf("expected stuck scrape to fail, got %+v", check)
+	}
+	e.health.scrapeDone(stuck)
+	if check := e.livenessChecks(now)[0]; check.Status != healthOK {
+		t.Errorf("expected recent scrape to be ok, got %+v", check)
+	}
+}
+
+func TestReadinessChecks(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+		"ApiVersionsRequest": sarama.NewMockWrapper(&sarama.ApiVersionsResponse{}),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	now := time.Now()
+	e := &Exporter{
+		client:                  client,
+		apiVersions:             newAPIVersionCache(),
+		metadataMaxAge:          time.Minute,
+		metadataRefreshInterval: time.Minute / 2,
+		nextMetadataRefresh:     now.Add(time.Minute),
+	}
+	e.health.metadataRefreshed(now.Add(-2*time.Minute), nil)
+	e.health.metadataRefreshed(now.Add(-time.Minute/2), sarama.ErrOutOfBrokers)
+
+	expected := map[string]string{"brokers": healthOK, "metadata": healthFailed, "sasl": healthOK}
+	checkReadiness(t, e.readinessChecks(now), expected)
+
+	// Once the refresh is due, the readiness checks refresh the metadata
+	// without waiting for a scrape.
+	later := now.Add(2 * time.Minute)
+	expected["metadata"] = healthOK
+	checkReadiness(t, e.readinessChecks(later), expected)
+	if next := e.nextMetadataRefresh; !next.Equal(later.Add(time.Minute / 2)) {
+		t.Errorf("expected the next refresh to be scheduled, got %v", next)
+	}
+}
+
+func checkReadiness(t *testing.T, checks []healthCheck, expected map[string]string) {
+	t.Helper()
+	if len(checks) != len(expected) {
+		t.Fatalf("expected %d checks, got %+v", len(expected), checks)
+	}
+	for _, check := range checks {
+		if check.Status != expected[check.Name] {
+			t.Errorf("expected %s check to be %s, got %+v", check.Name, expected[check.Name], check)
+		}
+	}
+}
+
+func TestIsSASLError(t *testing.T) {
+	for err, expected := range map[error]bool{
+		sarama.ErrSASLAuthenticationFailed:                             true,
+		sarama.ErrUnsupportedSASLMechanism:                             true,
+		fmt.Errorf("broker 1: %w", sarama.ErrSASLAuthenticationFailed): true,
+		sarama.ErrOutOfBrokers:                                         false,
+		sarama.ErrNotConnected:                                         false,
+		// Only the errors returned by the broker are authentication
+		// failures.
+		errors.New("dial tcp: lookup SASL-broker: no such host"): false,
+	} {
+		if isSASLError(err) != expected {
+			t.Errorf("%v: expected %t", err, expected)
+		}
+	}
+}
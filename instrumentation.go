@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The phases of a scrape, in order. The topic configs are fetched during the
+// metadata phase and the committed record timestamps during the groups phase.
+const (
+	phaseMetadata   = "metadata"
+	phaseBrokers    = "brokers"
+	phaseLogDirs    = "log_dirs"
+	phaseOffsets    = "offsets"
+	phaseTimestamps = "timestamps"
+	phaseZooKeeper  = "zookeeper"
+	phaseGroups     = "groups"
+)
+
+var scrapePhases = []string{phaseMetadata, phaseBrokers, phaseLogDirs, phaseOffsets, phaseTimestamps, phaseZooKeeper, phaseGroups}
+
+type requestKey struct {
+	api    string
+	broker int32
+}
+
+type requestCount struct {
+	total  float64
+	errors float64
+}
+
+// scrapeStats counts the requests the exporter sends to the brokers and the
+// errors that made scrapes skip some metrics. The zero value is ready to use.
+type scrapeStats struct {
+	mu       sync.Mutex
+	requests map[requestKey]*requestCount
+	errors   map[string]float64
+}
+
+// request counts a request of the Kafka API sent to the broker, which failed
+// when err is not nil.
+func (s *scrapeStats) request(api string, broker *sarama.Broker, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.requests == nil {
+		s.requests = make(map[requestKey]*requestCount)
+	}
+	key := requestKey{api: api, broker: broker.ID()}
+	count, ok := s.requests[key]
+	if !ok {
+		count = &requestCount{}
+		s.requests[key] = count
+	}
+	count.total++
+	if err != nil {
+		count.errors++
+	}
+}
+
+// scrapeError counts an error of the scrape phase.
+func (s *scrapeStats) scrapeError(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.errors == nil {
+		s.errors = make(map[string]float64)
+	}
+	s.errors[phase]++
+}
+
+func (s *scrapeStats) collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, count := range s.requests {
+		broker := strconv.Itoa(int(key.broker))
+		ch <- prometheus.MustNewConstMetric(
+			exporterRequests, prometheus.CounterValue, count.total, key.api, broker,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			exporterRequestErrors, prometheus.CounterValue, count.errors, key.api, broker,
+		)
+	}
+	for _, phase := range scrapePhases {
+		ch <- prometheus.MustNewConstMetric(
+			exporterScrapeErrors, prometheus.CounterValue, s.errors[phase], phase,
+		)
+	}
+}
+
+// scrapeErrorf logs an error that made the scrape phase skip some metrics,
+// and counts it.
+func (e *Exporter) scrapeErrorf(phase string, format string, args ...interface{}) {
+	e.stats.scrapeError(phase)
+	glog.ErrorDepth(1, fmt.Sprintf(format, args...))
+}
+
+// observePhase exports the duration of the scrape phase started at start.
+func observePhase(ch chan<- prometheus.Metric, phase string, start time.Time) {
+	ch <- prometheus.MustNewConstMetric(
+		exporterScrapePhaseDuration, prometheus.GaugeValue, time.Since(start).Seconds(), phase,
+	)
+}
@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestScrapeStats(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	descs := exporterTestDescs()
+	e := newTestExporter(t, first, kafkaOpts{})
+	defer e.Close()
+	// The requests to broker 2 fail, so do the phases sending them.
+	second.Close()
+
+	collectTestMetrics(t, descs, e.Collect)
+	metrics := collectTestMetrics(t, descs, e.Collect)
+
+	// The counters add up over both scrapes.
+	for key, expected := range map[string]float64{
+		`kafka_exporter_kafka_requests_total{api="ApiVersions",broker="1"}`: 2,
+		`kafka_exporter_kafka_requests_total{api="ApiVersions",broker="2"}`: 2,
+		// The topic configs are kept until the next metadata refresh.
+		`kafka_exporter_kafka_requests_total{api="DescribeConfigs",broker="1"}`:       1,
+		`kafka_exporter_kafka_requests_total{api="ListOffsets",broker="1"}`:           4,
+		`kafka_exporter_kafka_requests_total{api="ListOffsets",broker="2"}`:           4,
+		`kafka_exporter_kafka_requests_total{api="ListGroups",broker="1"}`:            2,
+		`kafka_exporter_kafka_requests_total{api="ListGroups",broker="2"}`:            2,
+		`kafka_exporter_kafka_request_errors_total{api="ApiVersions",broker="1"}`:     0,
+		`kafka_exporter_kafka_request_errors_total{api="ApiVersions",broker="2"}`:     2,
+		`kafka_exporter_kafka_request_errors_total{api="DescribeConfigs",broker="1"}`: 0,
+		`kafka_exporter_kafka_request_errors_total{api="ListOffsets",broker="1"}`:     0,
+		`kafka_exporter_kafka_request_errors_total{api="ListOffsets",broker="2"}`:     4,
+		`kafka_exporter_kafka_request_errors_total{api="ListGroups",broker="1"}`:      0,
+		`kafka_exporter_kafka_request_errors_total{api="ListGroups",broker="2"}`:      2,
+		`kafka_exporter_scrape_errors_total{phase="metadata"}`:                        0,
+		`kafka_exporter_scrape_errors_total{phase="brokers"}`:                         2,
+		`kafka_exporter_scrape_errors_total{phase="log_dirs"}`:                        0,
+		`kafka_exporter_scrape_errors_total{phase="offsets"}`:                         4,
+		`kafka_exporter_scrape_errors_total{phase="timestamps"}`:                      0,
+		`kafka_exporter_scrape_errors_total{phase="zookeeper"}`:                       0,
+		`kafka_exporter_scrape_errors_total{phase="groups"}`:                          2,
+	} {
+		if value, ok := metrics[key]; !ok || value != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", key, expected, value, ok)
+		}
+	}
+
+	// Only the phases that ran are timed.
+	for _, phase := range scrapePhases {
+		value, ok := metrics[`kafka_exporter_scrape_phase_duration_seconds{phase="`+phase+`"}`]
+		switch phase {
+		case phaseMetadata, phaseBrokers, phaseOffsets, phaseGroups:
+			if !ok || value < 0 {
+				t.Errorf("expected the duration of the phase %s, got %v (%t)", phase, value, ok)
+			}
+		default:
+			if ok {
+				t.Errorf("expected no duration of the disabled phase %s, got %v", phase, value)
+			}
+		}
+	}
+	if value, ok := metrics[`kafka_exporter_scrape_duration_seconds{}`]; !ok || value <= 0 {
+		t.Errorf("expected the duration of the scrape, got %v (%t)", value, ok)
+	}
+}
@@ -24,7 +24,6 @@ import (
 	plog "github.com/prometheus/common/promlog"
 	plogflag "github.com/prometheus/common/promlog/flag"
 	"github.com/prometheus/common/version"
-	"github.com/rcrowley/go-metrics"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -32,29 +31,75 @@ const (
 	namespace = "kafka"
 	clientID  = "kafka_exporter"
 )
-var lastOffset = make(map[string]map[string]int64)
-var  topicOffset = make(map[string]int64)
-var (
-	lastScrape int64
-	start bool=true
-)
+
 var (
 	clusterBrokers                     *prometheus.Desc
+	brokerInfo                         *prometheus.Desc
+	brokerIsController                 *prometheus.Desc
+	brokerUp                           *prometheus.Desc
+	brokerAPIVersionInfo               *prometheus.Desc
+	brokerLeaderPartitions             *prometheus.Desc
+	brokerReplicaPartitions            *prometheus.Desc
 	topicPartitions                    *prometheus.Desc
 	topicCurrentOffset                 *prometheus.Desc
 	topicOldestOffset                  *prometheus.Desc
+	topicPartitionNewestTimestamp      *prometheus.Desc
+	topicPartitionOldestTimestamp      *prometheus.Desc
+	topicProduceRate                   *prometheus.Desc
+	topicPartitionMessagesIn           *prometheus.Desc
 	topicPartitionLeader               *prometheus.Desc
 	topicPartitionReplicas             *prometheus.Desc
 	topicPartitionInSyncReplicas       *prometheus.Desc
 	topicPartitionUsesPreferredReplica *prometheus.Desc
 	topicUnderReplicatedPartition      *prometheus.Desc
+	topicUnderMinISRPartition          *prometheus.Desc
+	topicOfflinePartition              *prometheus.Desc
+	clusterUnderReplicatedPartitions   *prometheus.Desc
+	clusterUnderMinISRPartitions       *prometheus.Desc
+	clusterOfflinePartitions           *prometheus.Desc
+	topicSize                          *prometheus.Desc
+	topicConfig                        *prometheus.Desc
+	topicConfigInfo                    *prometheus.Desc
+	logDirOnline                       *prometheus.Desc
+	logDirPartitionSize                *prometheus.Desc
+	logDirPartitionOffsetLag           *prometheus.Desc
 	consumergroupCurrentOffset         *prometheus.Desc
 	consumergroupCurrentOffsetSum      *prometheus.Desc
 	consumergroupLag                   *prometheus.Desc
-	//consumergroupLagSum                *prometheus.Desc
-	consumergroupLagSumRate				*prometheus.Desc
+	consumergroupLagSeconds            *prometheus.Desc
+	consumergroupLagSum                *prometheus.Desc
+	consumergroupConsumeRate           *prometheus.Desc
+	consumergroupStatus                *prometheus.Desc
+	consumergroupPartitionStatus       *prometheus.Desc
+	consumergroupMessagesConsumed      *prometheus.Desc
+	consumergroupLagDrainSeconds       *prometheus.Desc
 	consumergroupLagZookeeper          *prometheus.Desc
+	consumergroupZookeeperMembers      *prometheus.Desc
+	consumergroupZookeeperOwner        *prometheus.Desc
 	consumergroupMembers               *prometheus.Desc
+	consumergroupState                 *prometheus.Desc
+	consumergroupInfo                  *prometheus.Desc
+	consumergroupMemberPartitions      *prometheus.Desc
+	consumergroupUnassignedPartitions  *prometheus.Desc
+	consumergroupCoordinator           *prometheus.Desc
+	consumergroupCommittedTimestamp    *prometheus.Desc
+	exporterLastScrapeTimestamp        *prometheus.Desc
+	exporterScrapeDuration             *prometheus.Desc
+	exporterScrapePhaseDuration        *prometheus.Desc
+	exporterScrapeErrors               *prometheus.Desc
+	exporterRequests                   *prometheus.Desc
+	exporterRequestErrors              *prometheus.Desc
+	exporterFilteredTopics             *prometheus.Desc
+	exporterFilteredGroups             *prometheus.Desc
+	exporterClusterUp                  *prometheus.Desc
+	clientIncomingBytes                *prometheus.Desc
+	clientOutgoingBytes                *prometheus.Desc
+	clientRequests                     *prometheus.Desc
+	clientResponses                    *prometheus.Desc
+	clientRequestsInFlight             *prometheus.Desc
+	clientRequestSize                  *prometheus.Desc
+	clientResponseSize                 *prometheus.Desc
+	clientRequestLatency               *prometheus.Desc
 )
 
 // Exporter collects Kafka stats from the given server and exports them using
@@ -63,9 +108,11 @@ type Exporter struct {
 	client                  sarama.Client
 	topicFilter             *regexp.Regexp
 	groupFilter             *regexp.Regexp
+	groupNames              []string
 	mu                      sync.Mutex
 	useZooKeeperLag         bool
 	zookeeperClient         *kazoo.Kazoo
+	metadataMu              sync.Mutex
 	nextMetadataRefresh     time.Time
 	metadataRefreshInterval time.Duration
 	offsetShowAll           bool
@@ -74,35 +121,83 @@ type Exporter struct {
 	sgMutex                 sync.Mutex
 	sgWaitCh                chan struct{}
 	sgChans                 []chan<- prometheus.Metric
-	consumerGroupFetchAll   bool
+	offsetHistory           *offsetHistory
+	topicRates              *rateTracker
+	groupRates              *rateTracker
+	topicMessages           *offsetCounters
+	groupMessages           *offsetCounters
+	groupStatus             *groupStatusTracker
+	scrapeInterval          time.Duration
+	snapshotMu              sync.RWMutex
+	snapshot                []prometheus.Metric
+	quit                    chan struct{}
+	loopDone                chan struct{}
+	topicConfigEnabled      bool
+	topicConfigNames        []string
+	topicConfigMu           sync.Mutex
+	topicConfigCache        map[string]map[string]string
+	nextTopicConfigRefresh  time.Time
+	logDirsEnabled          bool
+	timestampsEnabled       bool
+	timestampFetches        chan struct{}
+	committedTimestamps     *committedTimestampCache
+	apiVersions             *apiVersionCache
+	stats                   scrapeStats
+	health                  healthState
+	maxScrapeDuration       time.Duration
+	metadataMaxAge          time.Duration
 }
 
 type kafkaOpts struct {
-	uri                      []string
-	useSASL                  bool
-	useSASLHandshake         bool
-	saslUsername             string
-	saslPassword             string
-	saslMechanism            string
-	useTLS                   bool
-	tlsCAFile                string
-	tlsCertFile              string
-	tlsKeyFile               string
-	tlsInsecureSkipTLSVerify bool
-	kafkaVersion             string
-	useZooKeeperLag          bool
-	uriZookeeper             []string
-	labels                   string
-	metadataRefreshInterval  string
-	serviceName              string
-	kerberosConfigPath       string
-	realm                    string
-	keyTabPath               string
-	kerberosAuthType         string
-	offsetShowAll            bool
-	topicWorkers             int
-	allowConcurrent          bool
-	verbosityLogLevel        int
+	uri                        []string
+	useSASL                    bool
+	useSASLHandshake           bool
+	saslUsername               string
+	saslPassword               string
+	saslPasswordFile           string
+	saslMechanism              string
+	oauthTokenURL              string
+	oauthClientID              string
+	oauthClientSecret          string
+	oauthClientSecretFile      string
+	oauthScopes                []string
+	oauthTokenFile             string
+	oauthTokenCommand          string
+	useTLS                     bool
+	tlsCAFile                  string
+	tlsCertFile                string
+	tlsKeyFile                 string
+	tlsKeyPassword             string
+	tlsKeyPasswordFile         string
+	tlsInsecureSkipTLSVerify   bool
+	kafkaVersion               string
+	groupNames                 []string
+	useZooKeeperLag            bool
+	uriZookeeper               []string
+	labels                     string
+	metadataRefreshInterval    string
+	serviceName                string
+	kerberosConfigPath         string
+	realm                      string
+	keyTabPath                 string
+	kerberosAuthType           string
+	offsetShowAll              bool
+	topicWorkers               int
+	allowConcurrent            bool
+	verbosityLogLevel          int
+	lagHistorySize             int
+	groupStatusWindow          int
+	probeTTL                   time.Duration
+	secretsCheckInterval       time.Duration
+	maxScrapeDuration          time.Duration
+	metadataMaxIntervals       int
+	scrapeInterval             string
+	topicConfigEnabled         bool
+	topicConfigNames           []string
+	logDirsEnabled             bool
+	timestampsEnabled          bool
+	timestampMaxRequests       int
+	committedTimestampsEnabled bool
 }
 
 // CanReadCertAndKey returns true if the certificate and key files already exists,
@@ -141,14 +236,33 @@ func canReadFile(path string) bool {
 
 // NewExporter returns an initialized Exporter.
 func NewExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Exporter, error) {
-	var zookeeperClient *kazoo.Kazoo
-	config := sarama.NewConfig()
-	config.ClientID = clientID
-	kafkaVersion, err := sarama.ParseKafkaVersion(opts.kafkaVersion)
+	exporter, err := newExporter(opts, topicFilter, groupFilter)
 	if err != nil {
 		return nil, err
 	}
-	config.Version = kafkaVersion
+	exporter.start()
+	return exporter, nil
+}
+
+// newExporter returns an Exporter whose background scrapes, if any, are not
+// started yet.
+func newExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Exporter, error) {
+	var (
+		zookeeperClient *kazoo.Kazoo
+		err             error
+	)
+	if err := opts.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	config := sarama.NewConfig()
+	config.ClientID = clientID
+	if opts.kafkaVersion != kafkaVersionAuto {
+		kafkaVersion, err := sarama.ParseKafkaVersion(opts.kafkaVersion)
+		if err != nil {
+			return nil, err
+		}
+		config.Version = kafkaVersion
+	}
 
 	if opts.useSASL {
 		// Convert to lowercase so that SHA512 and SHA256 is still valid
@@ -173,10 +287,16 @@ func NewExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Expor
 				config.Net.SASL.GSSAPI.AuthType = sarama.KRB5_USER_AUTH
 				config.Net.SASL.GSSAPI.Password = opts.saslPassword
 			}
+		case "oauthbearer":
+			config.Net.SASL.Mechanism = sarama.SASLMechanism(sarama.SASLTypeOAuth)
+			config.Net.SASL.TokenProvider, err = newTokenProvider(opts)
+			if err != nil {
+				return nil, err
+			}
 		case "plain":
 		default:
 			return nil, fmt.Errorf(
-				`invalid sasl mechanism "%s": can only be "scram-sha256", "scram-sha512", "gssapi" or "plain"`,
+				`invalid sasl mechanism "%s": can only be "scram-sha256", "scram-sha512", "gssapi", "oauthbearer" or "plain"`,
 				opts.saslMechanism,
 			)
 		}
@@ -214,7 +334,7 @@ func NewExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Expor
 			return nil, errors.Wrap(err, "error reading cert and key")
 		}
 		if canReadCertAndKey {
-			cert, err := tls.LoadX509KeyPair(opts.tlsCertFile, opts.tlsKeyFile)
+			cert, err := loadX509KeyPair(opts.tlsCertFile, opts.tlsKeyFile, opts.tlsKeyPassword)
 			if err == nil {
 				config.Net.TLS.Config.Certificates = []tls.Certificate{cert}
 			} else {
@@ -238,18 +358,59 @@ func NewExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Expor
 
 	config.Metadata.RefreshFrequency = interval
 
+	var scrapeInterval time.Duration
+	if opts.scrapeInterval != "" {
+		scrapeInterval, err = time.ParseDuration(opts.scrapeInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot parse scrape interval")
+		}
+	}
+
+	if opts.groupStatusWindow == 1 {
+		return nil, errors.New("group status window must hold at least 2 scrapes")
+	}
+
+	if opts.kafkaVersion == kafkaVersionAuto {
+		config.Version, err = detectKafkaVersion(opts.uri, config)
+		if err != nil {
+			return nil, err
+		}
+		glog.Infof("Using Kafka version %s", config.Version)
+	}
+
 	client, err := sarama.NewClient(opts.uri, config)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "Error Init Kafka Client")
 	}
 
+	var history *offsetHistory
+	if opts.lagHistorySize > 0 {
+		history = newOffsetHistory(opts.lagHistorySize)
+	}
+
+	var groupStatus *groupStatusTracker
+	if opts.groupStatusWindow > 0 {
+		groupStatus = newGroupStatusTracker(opts.groupStatusWindow)
+	}
+
+	var committedTimestamps *committedTimestampCache
+	if opts.committedTimestampsEnabled {
+		committedTimestamps = newCommittedTimestampCache()
+	}
+
+	var timestampFetches chan struct{}
+	if opts.timestampMaxRequests > 0 {
+		timestampFetches = make(chan struct{}, opts.timestampMaxRequests)
+	}
+
 	glog.Infoln("Done Init Clients")
 	// Init our exporter.
-	return &Exporter{
+	exporter := &Exporter{
 		client:                  client,
 		topicFilter:             regexp.MustCompile(topicFilter),
 		groupFilter:             regexp.MustCompile(groupFilter),
+		groupNames:              opts.groupNames,
 		useZooKeeperLag:         opts.useZooKeeperLag,
 		zookeeperClient:         zookeeperClient,
 		nextMetadataRefresh:     time.Now(),
@@ -260,42 +421,174 @@ func NewExporter(opts kafkaOpts, topicFilter string, groupFilter string) (*Expor
 		sgMutex:                 sync.Mutex{},
 		sgWaitCh:                nil,
 		sgChans:                 []chan<- prometheus.Metric{},
-		consumerGroupFetchAll:   config.Version.IsAtLeast(sarama.V2_0_0_0),
-	}, nil
+		offsetHistory:           history,
+		topicRates:              newRateTracker(),
+		groupRates:              newRateTracker(),
+		topicMessages:           newOffsetCounters(),
+		groupMessages:           newOffsetCounters(),
+		groupStatus:             groupStatus,
+		scrapeInterval:          scrapeInterval,
+		quit:                    make(chan struct{}),
+		topicConfigEnabled:      opts.topicConfigEnabled,
+		topicConfigNames:        opts.topicConfigNames,
+		logDirsEnabled:          opts.logDirsEnabled,
+		timestampsEnabled:       opts.timestampsEnabled,
+		timestampFetches:        timestampFetches,
+		committedTimestamps:     committedTimestamps,
+		apiVersions:             newAPIVersionCache(),
+		maxScrapeDuration:       opts.maxScrapeDuration,
+		metadataMaxAge:          time.Duration(opts.metadataMaxIntervals) * interval,
+	}
+	// Creating the client fetched the metadata.
+	exporter.health.metadataRefreshed(time.Now(), nil)
+	return exporter, nil
+}
+
+// start starts the background scrapes, if any.
+func (e *Exporter) start() {
+	if e.scrapeInterval > 0 {
+		e.loopDone = make(chan struct{})
+		go e.scrapeLoop()
+	}
+}
+
+// inherit takes over the state kept across scrapes by previous, which
+// exported the same cluster with a Kafka client that is being replaced, so
+// that the rates, counters and statuses carry on. previous must be closed
+// and e not started yet.
+func (e *Exporter) inherit(previous *Exporter) {
+	e.offsetHistory = previous.offsetHistory
+	e.topicRates = previous.topicRates
+	e.groupRates = previous.groupRates
+	e.topicMessages = previous.topicMessages
+	e.groupMessages = previous.groupMessages
+	e.groupStatus = previous.groupStatus
+	e.committedTimestamps = previous.committedTimestamps
+	e.stats.requests, e.stats.errors = previous.stats.requests, previous.stats.errors
+	// Served until the first background scrape completes.
+	e.snapshot = previous.snapshot
+}
+
+// Close stops the background scrapes, waiting for the one in progress, and
+// releases the Kafka and ZooKeeper clients.
+func (e *Exporter) Close() error {
+	close(e.quit)
+	if e.loopDone != nil {
+		<-e.loopDone
+	}
+	if e.zookeeperClient != nil {
+		e.zookeeperClient.Close()
+	}
+	return e.client.Close()
 }
 
-func (e *Exporter) fetchOffsetVersion() int16 {
-	version := e.client.Config().Version
-	if e.client.Config().Version.IsAtLeast(sarama.V2_0_0_0) {
-		return 4
-	} else if version.IsAtLeast(sarama.V0_10_2_0) {
-		return 2
-	} else if version.IsAtLeast(sarama.V0_8_2_2) {
-		return 1
+// scrapeLoop scrapes Kafka every scrapeInterval and keeps the result as the
+// snapshot served by Collect, until the Exporter is closed.
+func (e *Exporter) scrapeLoop() {
+	defer close(e.loopDone)
+	ticker := time.NewTicker(e.scrapeInterval)
+	defer ticker.Stop()
+	for {
+		snapshot := e.collectMetrics()
+		e.snapshotMu.Lock()
+		e.snapshot = snapshot
+		e.snapshotMu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-e.quit:
+			return
+		}
 	}
-	return 0
 }
 
 // Describe describes all the metrics ever exported by the Kafka exporter. It
 // implements prometheus.Collector.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- clusterBrokers
+	ch <- brokerInfo
+	ch <- brokerIsController
+	ch <- brokerUp
+	ch <- brokerAPIVersionInfo
+	ch <- brokerLeaderPartitions
+	ch <- brokerReplicaPartitions
 	ch <- topicCurrentOffset
 	ch <- topicOldestOffset
+	ch <- topicPartitionNewestTimestamp
+	ch <- topicPartitionOldestTimestamp
+	ch <- topicProduceRate
+	ch <- topicPartitionMessagesIn
 	ch <- topicPartitions
 	ch <- topicPartitionLeader
 	ch <- topicPartitionReplicas
 	ch <- topicPartitionInSyncReplicas
 	ch <- topicPartitionUsesPreferredReplica
 	ch <- topicUnderReplicatedPartition
+	ch <- topicUnderMinISRPartition
+	ch <- topicOfflinePartition
+	ch <- clusterUnderReplicatedPartitions
+	ch <- clusterUnderMinISRPartitions
+	ch <- clusterOfflinePartitions
+	ch <- topicSize
+	ch <- topicConfig
+	ch <- topicConfigInfo
+	ch <- logDirOnline
+	ch <- logDirPartitionSize
+	ch <- logDirPartitionOffsetLag
 	ch <- consumergroupCurrentOffset
 	ch <- consumergroupCurrentOffsetSum
 	ch <- consumergroupLag
+	ch <- consumergroupLagSeconds
+	ch <- consumergroupLagSum
+	ch <- consumergroupConsumeRate
+	ch <- consumergroupStatus
+	ch <- consumergroupPartitionStatus
+	ch <- consumergroupMessagesConsumed
+	ch <- consumergroupLagDrainSeconds
 	ch <- consumergroupLagZookeeper
-	//ch <- consumergroupLagSum
+	ch <- consumergroupZookeeperMembers
+	ch <- consumergroupZookeeperOwner
+	ch <- consumergroupMembers
+	ch <- consumergroupState
+	ch <- consumergroupInfo
+	ch <- consumergroupMemberPartitions
+	ch <- consumergroupUnassignedPartitions
+	ch <- consumergroupCoordinator
+	ch <- consumergroupCommittedTimestamp
+	ch <- exporterLastScrapeTimestamp
+	ch <- exporterScrapeDuration
+	ch <- exporterScrapePhaseDuration
+	ch <- exporterScrapeErrors
+	ch <- exporterRequests
+	ch <- exporterRequestErrors
+	ch <- exporterFilteredTopics
+	ch <- exporterFilteredGroups
+	ch <- clientIncomingBytes
+	ch <- clientOutgoingBytes
+	ch <- clientRequests
+	ch <- clientResponses
+	ch <- clientRequestsInFlight
+	ch <- clientRequestSize
+	ch <- clientResponseSize
+	ch <- clientRequestLatency
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	// The counters of the exporter and its client are always current, even
+	// when serving a previous scrape.
+	defer e.collectClientMetrics(ch)
+	defer e.stats.collect(ch)
+
+	if e.scrapeInterval > 0 {
+		// Serve the last complete background scrape.
+		e.snapshotMu.RLock()
+		snapshot := e.snapshot
+		e.snapshotMu.RUnlock()
+		for _, metric := range snapshot {
+			ch <- metric
+		}
+		return
+	}
 	if e.allowConcurrent {
 		e.collect(ch)
 		return
@@ -321,15 +614,7 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 // Collect fetches the stats from configured Kafka location and delivers them
 // as Prometheus metrics. It implements prometheus.Collector.
 func (e *Exporter) collectChans(quit chan struct{}) {
-	original := make(chan prometheus.Metric)
-	container := make([]prometheus.Metric, 0, 100)
-	go func() {
-		for metric := range original {
-			container = append(container, metric)
-		}
-	}()
-	e.collect(original)
-	close(original)
+	container := e.collectMetrics()
 	// Lock to avoid modification on the channel slice
 	e.sgMutex.Lock()
 	for _, ch := range e.sgChans {
@@ -345,39 +630,72 @@ func (e *Exporter) collectChans(quit chan struct{}) {
 	e.sgMutex.Unlock()
 }
 
+// collectMetrics runs a scrape and returns all the collected metrics.
+func (e *Exporter) collectMetrics() []prometheus.Metric {
+	original := make(chan prometheus.Metric)
+	container := make([]prometheus.Metric, 0, 100)
+	done := make(chan struct{})
+	go func() {
+		for metric := range original {
+			container = append(container, metric)
+		}
+		close(done)
+	}()
+	e.collect(original)
+	close(original)
+	<-done
+	return container
+}
+
 func (e *Exporter) collect(ch chan<- prometheus.Metric) {
+	start := time.Now()
+	scrape := e.health.scrapeStarted(start)
+	defer e.health.scrapeDone(scrape)
+	defer func() {
+		end := time.Now()
+		ch <- prometheus.MustNewConstMetric(
+			exporterLastScrapeTimestamp, prometheus.GaugeValue, float64(end.UnixNano())/float64(time.Second),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			exporterScrapeDuration, prometheus.GaugeValue, end.Sub(start).Seconds(),
+		)
+	}()
 
-	// 距离上次消费了多少
-	var consume int64
-	// 距离上次消费速率
-	var consumeRate float64
-	var consumeTime float64
 	var wg = sync.WaitGroup{}
 	ch <- prometheus.MustNewConstMetric(
 		clusterBrokers, prometheus.GaugeValue, float64(len(e.client.Brokers())),
 	)
-	// offset字典里存储的是各topic的各分区下一个offset的值
-	offset := make(map[string]map[int32]int64)
 	topicsPartitions := make(map[string][]int32)
+	// Partitions to fetch the offsets of, grouped by leader
+	leaders := make(map[*sarama.Broker]map[string][]int32)
+	leaderPartitions := make(map[int32]int)
+	replicaPartitions := make(map[int32]int)
+	var underReplicatedPartitions, underMinISRPartitions, offlinePartitions int
 
 	now := time.Now()
 
-	if now.After(e.nextMetadataRefresh) {
-		glog.Info("Refreshing client metadata")
-
-		if err := e.client.RefreshMetadata(); err != nil {
-			glog.Errorf("Cannot refresh topics, using cached data: %v", err)
-		}
-
-		e.nextMetadataRefresh = now.Add(e.metadataRefreshInterval)
+	if err := e.refreshMetadata(now); err != nil {
+		e.scrapeErrorf(phaseMetadata, "Cannot refresh topics, using cached data: %v", err)
 	}
 	// 获取topic列表
 	topics, err := e.client.Topics()
 	if err != nil {
-		glog.Errorf("Cannot get topics: %v", err)
+		e.scrapeErrorf(phaseMetadata, "Cannot get topics: %v", err)
+		observePhase(ch, phaseMetadata, now)
 		return
 	}
 	//glog.Infoln("获取topic列表")
+	collectedTopics := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		if e.topicFilter.MatchString(topic) {
+			collectedTopics = append(collectedTopics, topic)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(
+		exporterFilteredTopics, prometheus.GaugeValue, float64(len(topics)-len(collectedTopics)),
+	)
+	topicConfigs := e.topicConfigs(collectedTopics)
+
 	topicChannel := make(chan string)
 
 	getTopicMetrics := func(topic string) {
@@ -390,62 +708,60 @@ func (e *Exporter) collect(ch chan<- prometheus.Metric) {
 		partitions, err := e.client.Partitions(topic)
 		//glog.Infoln("获取topic 分区列表")
 		if err != nil {
-			glog.Errorf("Cannot get partitions of topic %s: %v", topic, err)
+			e.scrapeErrorf(phaseMetadata, "Cannot get partitions of topic %s: %v", topic, err)
 			return
 		}
 		ch <- prometheus.MustNewConstMetric(
 			topicPartitions, prometheus.GaugeValue, float64(len(partitions)), topic,
 		)
 		e.mu.Lock()
-		// topic 的分区数量作为容量
-		offset[topic] = make(map[int32]int64, len(partitions))
 		topicsPartitions[topic] = partitions
 		//glog.Infoln("添加分区列表完毕")
 		e.mu.Unlock()
 		for _, partition := range partitions {
 			broker, err := e.client.Leader(topic, partition)
-			if err != nil {
-				glog.Errorf("Cannot get leader of topic %s partition %d: %v", topic, partition, err)
-			} else {
+			if err == sarama.ErrLeaderNotAvailable {
 				ch <- prometheus.MustNewConstMetric(
-					topicPartitionLeader, prometheus.GaugeValue, float64(broker.ID()), topic, strconv.FormatInt(int64(partition), 10),
+					topicOfflinePartition, prometheus.GaugeValue, float64(1), topic, strconv.FormatInt(int64(partition), 10),
 				)
-			}
-			// 获取最新的生产offset值
-			currentOffset, err := e.client.GetOffset(topic, partition, sarama.OffsetNewest)
-			if err != nil {
-				glog.Errorf("Cannot get current offset of topic %s partition %d: %v", topic, partition, err)
-			} else {
 				e.mu.Lock()
-				// topic各分区最新的漂移值
-				offset[topic][partition] = currentOffset
+				offlinePartitions++
 				e.mu.Unlock()
+			} else if err != nil {
+				e.scrapeErrorf(phaseMetadata, "Cannot get leader of topic %s partition %d: %v", topic, partition, err)
+			} else {
 				ch <- prometheus.MustNewConstMetric(
-					topicCurrentOffset, prometheus.GaugeValue, float64(currentOffset), topic, strconv.FormatInt(int64(partition), 10),
+					topicOfflinePartition, prometheus.GaugeValue, float64(0), topic, strconv.FormatInt(int64(partition), 10),
 				)
-			}
-
-			oldestOffset, err := e.client.GetOffset(topic, partition, sarama.OffsetOldest)
-			if err != nil {
-				glog.Errorf("Cannot get oldest offset of topic %s partition %d: %v", topic, partition, err)
-			} else {
 				ch <- prometheus.MustNewConstMetric(
-					topicOldestOffset, prometheus.GaugeValue, float64(oldestOffset), topic, strconv.FormatInt(int64(partition), 10),
+					topicPartitionLeader, prometheus.GaugeValue, float64(broker.ID()), topic, strconv.FormatInt(int64(partition), 10),
 				)
+				e.mu.Lock()
+				if _, ok := leaders[broker]; !ok {
+					leaders[broker] = make(map[string][]int32)
+				}
+				leaders[broker][topic] = append(leaders[broker][topic], partition)
+				leaderPartitions[broker.ID()]++
+				e.mu.Unlock()
 			}
 
 			replicas, err := e.client.Replicas(topic, partition)
 			if err != nil {
-				glog.Errorf("Cannot get replicas of topic %s partition %d: %v", topic, partition, err)
+				e.scrapeErrorf(phaseMetadata, "Cannot get replicas of topic %s partition %d: %v", topic, partition, err)
 			} else {
 				ch <- prometheus.MustNewConstMetric(
 					topicPartitionReplicas, prometheus.GaugeValue, float64(len(replicas)), topic, strconv.FormatInt(int64(partition), 10),
 				)
+				e.mu.Lock()
+				for _, replica := range replicas {
+					replicaPartitions[replica]++
+				}
+				e.mu.Unlock()
 			}
 
 			inSyncReplicas, err := e.client.InSyncReplicas(topic, partition)
 			if err != nil {
-				glog.Errorf("Cannot get in-sync replicas of topic %s partition %d: %v", topic, partition, err)
+				e.scrapeErrorf(phaseMetadata, "Cannot get in-sync replicas of topic %s partition %d: %v", topic, partition, err)
 			} else {
 				ch <- prometheus.MustNewConstMetric(
 					topicPartitionInSyncReplicas, prometheus.GaugeValue, float64(len(inSyncReplicas)), topic, strconv.FormatInt(int64(partition), 10),
@@ -466,29 +782,26 @@ func (e *Exporter) collect(ch chan<- prometheus.Metric) {
 				ch <- prometheus.MustNewConstMetric(
 					topicUnderReplicatedPartition, prometheus.GaugeValue, float64(1), topic, strconv.FormatInt(int64(partition), 10),
 				)
+				e.mu.Lock()
+				underReplicatedPartitions++
+				e.mu.Unlock()
 			} else {
 				ch <- prometheus.MustNewConstMetric(
 					topicUnderReplicatedPartition, prometheus.GaugeValue, float64(0), topic, strconv.FormatInt(int64(partition), 10),
 				)
 			}
 
-			if e.useZooKeeperLag {
-				ConsumerGroups, err := e.zookeeperClient.Consumergroups()
-
-				if err != nil {
-					glog.Errorf("Cannot get consumer group %v", err)
-				}
-
-				for _, group := range ConsumerGroups {
-					offset, _ := group.FetchOffset(topic, partition)
-					if offset > 0 {
-
-						consumerGroupLag := currentOffset - offset
-						ch <- prometheus.MustNewConstMetric(
-							consumergroupLagZookeeper, prometheus.GaugeValue, float64(consumerGroupLag), group.Name, topic, strconv.FormatInt(int64(partition), 10),
-						)
-					}
+			if minISR, ok := minInsyncReplicas(topicConfigs, topic); ok && inSyncReplicas != nil {
+				underMinISR := 0
+				if len(inSyncReplicas) < minISR {
+					underMinISR = 1
+					e.mu.Lock()
+					underMinISRPartitions++
+					e.mu.Unlock()
 				}
+				ch <- prometheus.MustNewConstMetric(
+					topicUnderMinISRPartition, prometheus.GaugeValue, float64(underMinISR), topic, strconv.FormatInt(int64(partition), 10),
+				)
 			}
 		}
 		//glog.Infoln("获取数据完毕")
@@ -513,7 +826,8 @@ func (e *Exporter) collect(ch chan<- prometheus.Metric) {
 		}
 	}
 
-	N := minx(len(topics)/2, e.topicWorkers)
+	// At least one worker, or a cluster of a single topic blocks the scrape.
+	N := minx(len(topics)/2+1, e.topicWorkers)
 
 	for w := 1; w <= N; w++ {
 		go loopTopics(w)
@@ -529,191 +843,152 @@ func (e *Exporter) collect(ch chan<- prometheus.Metric) {
 	close(topicChannel)
 
 	wg.Wait()
+	observePhase(ch, phaseMetadata, now)
 
-	getConsumerGroupMetrics := func(broker *sarama.Broker) {
-		defer wg.Done()
-		// 建立kafka broker连接
-
-		var timeDiff int64
-		time := time.Now().Unix()
-		if start== true {
-			timeDiff = 0
-		}else {
-			timeDiff = time - lastScrape
-		}
-	//	glog.Infoln("timeDiff,",timeDiff)
-		if err := broker.Open(e.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
-			glog.Errorf("Cannot connect to broker %d: %v", broker.ID(), err)
-			return
-		}
-		//glog.Infoln("建立kafka broker连接")
-		defer broker.Close()
-		// 获取该broker的groups信息
-		groups, err := broker.ListGroups(&sarama.ListGroupsRequest{})
-		if err != nil {
-			glog.Errorf("Cannot get consumer group: %v", err)
-			return
-		}
-		//glog.Infoln("获取该broker的groups信息",groups)
-		groupIds := make([]string, 0)
-		// 将groups添加到groups切片
-		for groupId := range groups.Groups {
-			if e.groupFilter.MatchString(groupId) {
-				groupIds = append(groupIds, groupId)
-			}
-		}
-		//log.Infoln("将groups添加到groups切片",groupIds)
+	phaseStart := time.Now()
+	e.collectBrokerMetrics(ch, leaderPartitions, replicaPartitions)
+	observePhase(ch, phaseBrokers, phaseStart)
 
+	ch <- prometheus.MustNewConstMetric(
+		clusterUnderReplicatedPartitions, prometheus.GaugeValue, float64(underReplicatedPartitions),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		clusterOfflinePartitions, prometheus.GaugeValue, float64(offlinePartitions),
+	)
+	if topicConfigs != nil {
+		ch <- prometheus.MustNewConstMetric(
+			clusterUnderMinISRPartitions, prometheus.GaugeValue, float64(underMinISRPartitions),
+		)
+	}
 
-		describeGroups, err := broker.DescribeGroups(&sarama.DescribeGroupsRequest{Groups: groupIds})
-		if err != nil {
-			glog.Errorf("Cannot get describe groups: %v", err)
-			return
-		}
-		//glog.Infoln(" broker.DescribeGroups")
-		for _, group := range describeGroups.Groups {
-			offsetFetchRequest := sarama.OffsetFetchRequest{ConsumerGroup: group.GroupId, Version: 1}
-			// 如果获取所有topic
-			if e.offsetShowAll {
-				for topic, partitions := range topicsPartitions {
-					for _, partition := range partitions {
-						// 将topic和对应的分区号添加到offsetFetchRequest的map里
-						offsetFetchRequest.AddPartition(topic, partition)
-					}
-				}
-			} else {
-				for _, member := range group.Members {
-					assignment, err := member.GetMemberAssignment()
-					if err != nil {
-						glog.Errorf("Cannot get GetMemberAssignment of group member %v : %v", member, err)
-						return
-					}
-					for topic, partions := range assignment.Topics {
-						for _, partition := range partions {
-							offsetFetchRequest.AddPartition(topic, partition)
-						}
-					}
-				}
+	if e.topicConfigEnabled {
+		e.collectTopicConfigMetrics(ch, topicConfigs)
+	}
+
+	if e.logDirsEnabled {
+		phaseStart = time.Now()
+		e.collectLogDirMetrics(ch, topicsPartitions)
+		observePhase(ch, phaseLogDirs, phaseStart)
+	}
+
+	phaseStart = time.Now()
+	// offset字典里存储的是各topic的各分区下一个offset的值
+	offset := e.fetchOffsets(leaders, sarama.OffsetNewest)
+	offsetTime := time.Now()
+	for topic, partitions := range offset {
+		for partition, currentOffset := range partitions {
+			if e.offsetHistory != nil {
+				e.offsetHistory.add(topic, partition, currentOffset, offsetTime)
 			}
 			ch <- prometheus.MustNewConstMetric(
-				consumergroupMembers, prometheus.GaugeValue, float64(len(group.Members)), group.GroupId,
+				topicCurrentOffset, prometheus.GaugeValue, float64(currentOffset), topic, strconv.FormatInt(int64(partition), 10),
 			)
-			// 获取消费位移
-			offsetFetchResponse, err := broker.FetchOffset(&offsetFetchRequest)
-			if err != nil {
-				glog.Errorf("Cannot get offset of group %s: %v", group.GroupId, err)
-				continue
-			}
+			messages := e.topicMessages.observe(offsetCounterKey{topic: topic, partition: partition}, currentOffset)
+			ch <- prometheus.MustNewConstMetric(
+				topicPartitionMessagesIn, prometheus.CounterValue, float64(messages), topic, strconv.FormatInt(int64(partition), 10),
+			)
+		}
+	}
 
-			for topic, partitions := range offsetFetchResponse.Blocks {
-				// If the topic is not consumed by that consumer group, skip it
-				topicConsumed := false
-				for _, offsetFetchResponseBlock := range partitions {
-					// 如果消费者组下没有与topic partition 关联的偏移量，Kafka将返回-1
-					// Kafka will return -1 if there is no offset associated with a topic-partition under that consumer group
-					if offsetFetchResponseBlock.Offset != -1 {
-						topicConsumed = true
-						break
-					}
-				}
-				// 如果有未关联的topic与partition，跳出此次循环
-				if !topicConsumed {
-					continue
-				}
-				var currentOffsetSum int64
-				var lagSum int64
-				for partition, offsetFetchResponseBlock := range partitions {
-					err := offsetFetchResponseBlock.Err
-					if err != sarama.ErrNoError {
-						glog.Errorf("Error for  partition %d :%v", partition, err.Error())
-						continue
-					}
-					// 获取当前分区的消费位移
-					currentOffset := offsetFetchResponseBlock.Offset
-					// 所有分区的消费偏移量加起来
-					if currentOffset != -1 {
-						currentOffsetSum += currentOffset
-					}
-					ch <- prometheus.MustNewConstMetric(
-						consumergroupCurrentOffset, prometheus.GaugeValue, float64(currentOffset), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
-					)
-
-					currentOffset, error := e.client.GetOffset(topic, partition, sarama.OffsetNewest)
-					if error != nil {
-						glog.Errorf("Cannot get current offset of topic %s partition %d: %v", topic, partition, err)
-					}
-
-					// If the topic is consumed by that consumer group, but no offset associated with the partition
-						// forcing lag to -1 to be able to alert on that
-						var lag int64
-						if offsetFetchResponseBlock.Offset == -1 {
-							lag = -1
-						} else {
-							// 积压=生产位移-消费位移
-							lag = currentOffset - offsetFetchResponseBlock.Offset
-							lagSum += lag
-						}
-						ch <- prometheus.MustNewConstMetric(
-							consumergroupLag, prometheus.GaugeValue, float64(lag), group.GroupId, topic, strconv.FormatInt(int64(partition), 10),
-						)
+	oldest := e.fetchOffsets(leaders, sarama.OffsetOldest)
+	for topic, partitions := range oldest {
+		for partition, oldestOffset := range partitions {
+			ch <- prometheus.MustNewConstMetric(
+				topicOldestOffset, prometheus.GaugeValue, float64(oldestOffset), topic, strconv.FormatInt(int64(partition), 10),
+			)
+		}
+	}
 
+	observePhase(ch, phaseOffsets, phaseStart)
 
-				}
-				// 速度的计算要用消费偏移
-				if start == false {
-					consume = currentOffsetSum - lastOffset[group.GroupId][topic]
-					if group.GroupId =="base-data-redis-0412"{
-						glog.Infoln("last===",lastOffset[group.GroupId][topic],"currentOffset==",currentOffsetSum,"consume===",consume)
-					}
-					//glog.Infoln("consume:",consume)
-					if consume <= 0 {
-						consumeRate = 0
-						consumeTime= -1
-					}else {
-						consumeRate = float64(consume) / float64(timeDiff)
-						consumeTime = float64(currentOffsetSum) / consumeRate
-					}
-				}else {
-					consumeRate = -1
-					consumeTime = -2
-				}
-				e.mu.Lock()
-				topicOffset[topic] = currentOffsetSum
-				lastOffset[group.GroupId] = topicOffset
-				e.mu.Unlock()
-				//glog.Infoln("consumeTime: ",consumeTime)
-				ch <- prometheus.MustNewConstMetric(
-					consumergroupLagSumRate,prometheus.GaugeValue,float64(lagSum),group.GroupId,topic,strconv.FormatFloat(consumeRate,'f',1,64),strconv.FormatFloat(consumeTime,'f',0,64),strconv.Itoa(int(timeDiff)))
-				ch <- prometheus.MustNewConstMetric(
-					consumergroupCurrentOffsetSum, prometheus.GaugeValue, float64(currentOffsetSum), group.GroupId, topic,
-				)
-				//ch <- prometheus.MustNewConstMetric(
-				//	consumergroupLagSum, prometheus.GaugeValue, float64(lagSum), group.GroupId, topic,
-				//)
-				if group.GroupId =="base-data-redis-0412"{
-					glog.Infoln("first===","last===",lastOffset[group.GroupId][topic],"currentOffset==",currentOffsetSum,)
-				}
+	if e.timestampsEnabled {
+		phaseStart = time.Now()
+		e.collectTimestampMetrics(ch, leaders, offset, oldest)
+		observePhase(ch, phaseTimestamps, phaseStart)
+	}
+
+	if e.useZooKeeperLag {
+		phaseStart = time.Now()
+		e.collectZooKeeperLagMetrics(ch, offset)
+		observePhase(ch, phaseZooKeeper, phaseStart)
+	}
+
+	// Topics whose partitions could not be listed keep their history and
+	// counters.
+	known := make(map[string][]int32, len(topicsPartitions))
+	for _, topic := range topics {
+		if e.topicFilter.MatchString(topic) {
+			known[topic] = topicsPartitions[topic]
+		}
+	}
+	if e.offsetHistory != nil {
+		e.offsetHistory.prune(known)
+	}
+	e.topicMessages.prune(func(key offsetCounterKey) bool {
+		partitions, ok := known[key.topic]
+		if !ok || partitions == nil {
+			return ok
+		}
+		for _, partition := range partitions {
+			if partition == key.partition {
+				return true
 			}
 		}
-		//glog.Infoln("结束")
+		return false
+	})
+
+	produceRates := make(map[string]float64, len(offset))
+	for topic, partitions := range offset {
+		// A partial sum would look like offsets going backwards.
+		if len(partitions) != len(topicsPartitions[topic]) {
+			continue
+		}
+		var sum int64
+		for _, partitionOffset := range partitions {
+			sum += partitionOffset
+		}
+		if rate, ok := e.topicRates.observe(rateKey{topic: topic}, sum, now); ok {
+			produceRates[topic] = rate
+			ch <- prometheus.MustNewConstMetric(
+				topicProduceRate, prometheus.GaugeValue, rate, topic,
+			)
+		}
 	}
+	e.topicRates.rotate()
 
 	glog.Info("Fetching consumer group metrics")
+	phaseStart = time.Now()
 	if len(e.client.Brokers()) > 0 {
-		for _, broker := range e.client.Brokers() {
-			wg.Add(1)
-			go getConsumerGroupMetrics(broker)
-		}
-		wg.Wait()
+		e.collectConsumerGroupMetrics(ch, &groupScrape{
+			topicsPartitions: topicsPartitions,
+			offsets:          offset,
+			produceRates:     produceRates,
+		})
 	} else {
-		glog.Errorln("No valid broker, cannot get consumer group metrics")
+		e.scrapeErrorf(phaseGroups, "No valid broker, cannot get consumer group metrics")
 	}
-	lastScrape = time.Now().Unix()
-	start = false
+	e.groupRates.rotate()
+	observePhase(ch, phaseGroups, phaseStart)
+}
+
+// refreshMetadata refreshes the metadata of the client once the refresh
+// interval elapsed. Both the scrapes and the readiness checks call it, so that
+// the metadata does not look stale between scrapes less frequent than the
+// refresh interval.
+func (e *Exporter) refreshMetadata(now time.Time) error {
+	e.metadataMu.Lock()
+	defer e.metadataMu.Unlock()
+	if !now.After(e.nextMetadataRefresh) {
+		return nil
+	}
+	glog.Info("Refreshing client metadata")
+	err := e.client.RefreshMetadata()
+	e.health.metadataRefreshed(now, err)
+	e.nextMetadataRefresh = now.Add(e.metadataRefreshInterval)
+	return err
 }
 
 func init() {
-	metrics.UseNilMetrics = true
 	prometheus.MustRegister(version.NewCollector("kafka_exporter"))
 }
 
@@ -726,9 +1001,11 @@ func main() {
 	var (
 		listenAddress = toFlag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9308").String()
 		metricsPath   = toFlag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		webConfigFile = toFlag("web.config.file", "Path to a YAML file enabling TLS or basic authentication on the web server, reloaded on every request.").Default("").String()
 		topicFilter   = toFlag("topic.filter", "Regex that determines which topics to collect.").Default(".*").String()
 		groupFilter   = toFlag("group.filter", "Regex that determines which consumer groups to collect.").Default(".*").String()
 		logSarama     = toFlag("log.enable-sarama", "Turn on Sarama logging.").Default("false").Bool()
+		configFile    = toFlag("config.file", "Path to a YAML file with the exporter settings and the Kafka clusters to scrape. Its settings override the flags. Reloaded on SIGHUP or a POST to /-/reload.").Default("").String()
 
 		opts = kafkaOpts{}
 	)
@@ -736,9 +1013,17 @@ func main() {
 	toFlag("kafka.server", "Address (host:port) of Kafka server.").Default("kafka:9092").StringsVar(&opts.uri)
 	toFlag("sasl.enabled", "Connect using SASL/PLAIN.").Default("false").BoolVar(&opts.useSASL)
 	toFlag("sasl.handshake", "Only set this to false if using a non-Kafka SASL proxy.").Default("true").BoolVar(&opts.useSASLHandshake)
-	toFlag("sasl.username", "SASL user name.").Default("").StringVar(&opts.saslUsername)
-	toFlag("sasl.password", "SASL user password.").Default("").StringVar(&opts.saslPassword)
-	toFlag("sasl.mechanism", "The SASL SCRAM SHA algorithm sha256 or sha512 or gssapi as mechanism").Default("").StringVar(&opts.saslMechanism)
+	toFlag("sasl.username", "SASL user name.").Default("").Envar("KAFKA_EXPORTER_SASL_USERNAME").StringVar(&opts.saslUsername)
+	toFlag("sasl.password", "SASL user password.").Default("").Envar("KAFKA_EXPORTER_SASL_PASSWORD").StringVar(&opts.saslPassword)
+	toFlag("sasl.password-file", "File holding the SASL user password, takes precedence over sasl.password").Default("").Envar("KAFKA_EXPORTER_SASL_PASSWORD_FILE").StringVar(&opts.saslPasswordFile)
+	toFlag("sasl.mechanism", "The SASL SCRAM SHA algorithm sha256 or sha512 or gssapi or oauthbearer as mechanism").Default("").StringVar(&opts.saslMechanism)
+	toFlag("sasl.oauthbearer.token-url", "OAuth token endpoint to request OAUTHBEARER tokens from with the client credentials grant").Default("").StringVar(&opts.oauthTokenURL)
+	toFlag("sasl.oauthbearer.client-id", "OAuth client ID for the client credentials grant").Default("").Envar("KAFKA_EXPORTER_SASL_OAUTHBEARER_CLIENT_ID").StringVar(&opts.oauthClientID)
+	toFlag("sasl.oauthbearer.client-secret", "OAuth client secret for the client credentials grant").Default("").Envar("KAFKA_EXPORTER_SASL_OAUTHBEARER_CLIENT_SECRET").StringVar(&opts.oauthClientSecret)
+	toFlag("sasl.oauthbearer.client-secret-file", "File holding the OAuth client secret, takes precedence over sasl.oauthbearer.client-secret").Default("").Envar("KAFKA_EXPORTER_SASL_OAUTHBEARER_CLIENT_SECRET_FILE").StringVar(&opts.oauthClientSecretFile)
+	toFlag("sasl.oauthbearer.scopes", "OAuth scopes requested with the client credentials grant").StringsVar(&opts.oauthScopes)
+	toFlag("sasl.oauthbearer.token-file", "File holding the OAUTHBEARER token, read again when it changes").Default("").StringVar(&opts.oauthTokenFile)
+	toFlag("sasl.oauthbearer.token-command", "Command printing the OAUTHBEARER token, run when a new token is needed").Default("").StringVar(&opts.oauthTokenCommand)
 	toFlag("sasl.service-name", "Service name when using kerberos Auth").Default("").StringVar(&opts.serviceName)
 	toFlag("sasl.kerberos-config-path", "Kerberos config path").Default("").StringVar(&opts.kerberosConfigPath)
 	toFlag("sasl.realm", "Kerberos realm").Default("").StringVar(&opts.realm)
@@ -748,16 +1033,32 @@ func main() {
 	toFlag("tls.ca-file", "The optional certificate authority file for TLS client authentication.").Default("").StringVar(&opts.tlsCAFile)
 	toFlag("tls.cert-file", "The optional certificate file for client authentication.").Default("").StringVar(&opts.tlsCertFile)
 	toFlag("tls.key-file", "The optional key file for client authentication.").Default("").StringVar(&opts.tlsKeyFile)
+	toFlag("tls.key-password", "Password of the encrypted key file for client authentication.").Default("").Envar("KAFKA_EXPORTER_TLS_KEY_PASSWORD").StringVar(&opts.tlsKeyPassword)
+	toFlag("tls.key-password-file", "File holding the password of the encrypted key file, takes precedence over tls.key-password.").Default("").Envar("KAFKA_EXPORTER_TLS_KEY_PASSWORD_FILE").StringVar(&opts.tlsKeyPasswordFile)
 	toFlag("tls.insecure-skip-tls-verify", "If true, the server's certificate will not be checked for validity. This will make your HTTPS connections insecure.").Default("false").BoolVar(&opts.tlsInsecureSkipTLSVerify)
-	toFlag("kafka.version", "Kafka broker version").Default(sarama.V2_0_0_0.String()).StringVar(&opts.kafkaVersion)
+	toFlag("kafka.version", "Kafka broker version, or auto to detect it from the brokers").Default(sarama.V2_0_0_0.String()).StringVar(&opts.kafkaVersion)
 	toFlag("use.consumelag.zookeeper", "if you need to use a group from zookeeper").Default("false").BoolVar(&opts.useZooKeeperLag)
 	toFlag("zookeeper.server", "Address (hosts) of zookeeper server.").Default("localhost:2181").StringsVar(&opts.uriZookeeper)
 	toFlag("kafka.labels", "Kafka cluster name").Default("").StringVar(&opts.labels)
 	toFlag("refresh.metadata", "Metadata refresh interval").Default("30s").StringVar(&opts.metadataRefreshInterval)
+	toFlag("group.names", "Consumer groups to collect whether they match the group filter or not, their coordinator is looked up even when no broker lists them. Can be repeated").StringsVar(&opts.groupNames)
 	toFlag("offset.show-all", "Whether show the offset/lag for all consumer group, otherwise, only show connected consumer groups").Default("true").BoolVar(&opts.offsetShowAll)
 	toFlag("concurrent.enable", "If true, all scrapes will trigger kafka operations otherwise, they will share results. WARN: This should be disabled on large clusters").Default("false").BoolVar(&opts.allowConcurrent)
 	toFlag("topic.workers", "Number of topic workers").Default("100").IntVar(&opts.topicWorkers)
 	toFlag("verbosity", "Verbosity log level").Default("0").IntVar(&opts.verbosityLogLevel)
+	toFlag("scrape.interval", "If set, Kafka is scraped in the background at this interval and the metrics endpoint serves the last complete scrape, otherwise Kafka is scraped on every request").Default("0s").StringVar(&opts.scrapeInterval)
+	toFlag("topic.config.enabled", "Whether to collect the configuration of the topics with DescribeConfigs, requires Kafka 0.11 or later").Default("false").BoolVar(&opts.topicConfigEnabled)
+	toFlag("topic.config.names", "Names of the topic configs to collect").Default("retention.ms", "retention.bytes", "min.insync.replicas", "cleanup.policy", "segment.bytes", "segment.ms").StringsVar(&opts.topicConfigNames)
+	toFlag("group.committed-timestamp.enabled", "Whether to collect the timestamps of the records at the offsets committed by the consumer groups, requires Kafka 0.10 or later").Default("false").BoolVar(&opts.committedTimestampsEnabled)
+	toFlag("topic.timestamps.enabled", "Whether to collect the timestamps of the newest and oldest records of the partitions, requires Kafka 0.10 or later").Default("false").BoolVar(&opts.timestampsEnabled)
+	toFlag("topic.timestamps.max-requests", "Most Fetch requests sent at once to read the timestamps of records, with topic.timestamps.enabled and group.committed-timestamp.enabled, 0 disables the limit").Default("10").IntVar(&opts.timestampMaxRequests)
+	toFlag("log-dirs.enabled", "Whether to collect the size of the partitions on disk with DescribeLogDirs, requires Kafka 1.0 or later").Default("false").BoolVar(&opts.logDirsEnabled)
+	toFlag("lag.history-size", "Number of newest offset samples kept per partition to estimate the consumer group lag in seconds, 0 disables it").Default("60").IntVar(&opts.lagHistorySize)
+	toFlag("probe.ttl", "Time after which the Kafka client of a target of the probe endpoint is closed when it is not probed, 0 keeps them open").Default("5m").DurationVar(&opts.probeTTL)
+	toFlag("health.max-scrape-duration", "Duration after which /healthz fails while a scrape is still running, 0 disables it").Default("5m").DurationVar(&opts.maxScrapeDuration)
+	toFlag("health.metadata-max-intervals", "Number of metadata refresh intervals after which /ready fails when the scrapes could not refresh the metadata, 0 disables it").Default("3").IntVar(&opts.metadataMaxIntervals)
+	toFlag("secrets.check-interval", "Interval at which the files holding credentials and certificates are checked for changes, which recreate the Kafka clients, 0 disables it").Default("30s").DurationVar(&opts.secretsCheckInterval)
+	toFlag("group.status-window", "Number of scrapes the status of the consumer groups is evaluated over, 0 disables it").Default("10").IntVar(&opts.groupStatusWindow)
 
 	plConfig := plog.Config{}
 	plogflag.AddFlags(kingpin.CommandLine, &plConfig)
@@ -765,19 +1066,7 @@ func main() {
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
-	labels := make(map[string]string)
-
-	// Protect against empty labels
-	if opts.labels != "" {
-		for _, label := range strings.Split(opts.labels, ",") {
-			splitted := strings.Split(label, "=")
-			if len(splitted) >= 2 {
-				labels[splitted[0]] = splitted[1]
-			}
-		}
-	}
-
-	setup(*listenAddress, *metricsPath, *topicFilter, *groupFilter, *logSarama, opts, labels)
+	setup(*listenAddress, *metricsPath, *topicFilter, *groupFilter, *logSarama, opts, parseLabels(opts.labels), *configFile, *webConfigFile)
 }
 
 func setup(
@@ -788,6 +1077,8 @@ func setup(
 	logSarama bool,
 	opts kafkaOpts,
 	labels map[string]string,
+	configFile string,
+	webConfigFile string,
 ) {
 	if err := flag.Set("logtostderr", "true"); err != nil {
 		glog.Errorf("Error on setting logtostderr to true")
@@ -799,11 +1090,80 @@ func setup(
 	glog.Infoln("Starting kafka_exporter", version.Info())
 	glog.Infoln("Build context", version.BuildContext())
 
+	initDescs(labels)
+
+	if logSarama {
+		sarama.Logger = log.New(os.Stdout, "[sarama] ", log.LstdFlags)
+	}
+
+	prometheus.MustRegister(configLastReloadSuccessful, configLastReloadSuccessTimestamp)
+	reloader, err := newReloader(configFile, newClusterConfig(opts, topicFilter, groupFilter), opts)
+	if err != nil {
+		glog.Fatalln(err)
+	}
+	defer reloader.Close()
+	reloader.watchSignals()
+	if opts.secretsCheckInterval > 0 {
+		reloader.watchSecrets(opts.secretsCheckInterval)
+	}
+
+	http.Handle(metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, reloader))
+	http.HandleFunc("/-/reload", reloader.reloadHandler)
+	http.HandleFunc("/probe", reloader.probeHandler)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>
+	        <head><title>Kafka Exporter</title></head>
+	        <body>
+	        <h1>Kafka Exporter</h1>
+	        <p><a href='` + metricsPath + `'>Metrics</a></p>
+	        </body>
+	        </html>`))
+	})
+	http.HandleFunc("/healthz", reloader.healthzHandler)
+	http.HandleFunc("/ready", reloader.readyHandler)
+
+	glog.Infoln("Listening on", listenAddress)
+	glog.Fatal(listenAndServe(listenAddress, webConfigFile, http.DefaultServeMux))
+}
+
+// initDescs creates the descs of the metrics, with the constant labels given
+// with --kafka.labels.
+func initDescs(labels map[string]string) {
 	clusterBrokers = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "brokers"),
 		"Number of Brokers in the Kafka Cluster.",
 		nil, labels,
 	)
+	brokerInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "info"),
+		"Address and rack of a Broker, always 1",
+		[]string{"id", "address", "rack"}, labels,
+	)
+	brokerIsController = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "is_controller"),
+		"1 if the Broker is the controller of the Kafka Cluster",
+		[]string{"id"}, labels,
+	)
+	brokerUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "up"),
+		"1 if the exporter could connect to the Broker and complete an ApiVersions request",
+		[]string{"id"}, labels,
+	)
+	brokerAPIVersionInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "api_version_info"),
+		"Newest Kafka version whose APIs the Broker supports, as reported by ApiVersions, always 1",
+		[]string{"id", "version"}, labels,
+	)
+	brokerLeaderPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "leader_partitions"),
+		"Number of partitions of the collected Topics led by the Broker",
+		[]string{"id"}, labels,
+	)
+	brokerReplicaPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "broker", "replica_partitions"),
+		"Number of partitions of the collected Topics replicated on the Broker",
+		[]string{"id"}, labels,
+	)
 	topicPartitions = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "topic", "partitions"),
 		"Number of partitions for this Topic",
@@ -820,6 +1180,30 @@ func setup(
 		[]string{"topic", "partition"}, labels,
 	)
 
+	topicPartitionNewestTimestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "partition_newest_timestamp_seconds"),
+		"Unix timestamp of the newest record at Topic/Partition",
+		[]string{"topic", "partition"}, labels,
+	)
+
+	topicPartitionOldestTimestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "partition_oldest_timestamp_seconds"),
+		"Unix timestamp of the oldest retained record at Topic/Partition",
+		[]string{"topic", "partition"}, labels,
+	)
+
+	topicProduceRate = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "produce_rate"),
+		"Messages produced per second to this Topic since the previous scrape",
+		[]string{"topic"}, labels,
+	)
+
+	topicPartitionMessagesIn = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "partition_messages_in_total"),
+		"Number of messages produced to Topic/Partition since the exporter started",
+		[]string{"topic", "partition"}, labels,
+	)
+
 	topicPartitionLeader = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "topic", "partition_leader"),
 		"Leader Broker ID of this Topic/Partition",
@@ -850,6 +1234,72 @@ func setup(
 		[]string{"topic", "partition"}, labels,
 	)
 
+	topicUnderMinISRPartition = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "partition_under_min_isr"),
+		"1 if Topic/Partition has less In-Sync Replicas than the min.insync.replicas of the Topic",
+		[]string{"topic", "partition"}, labels,
+	)
+
+	topicOfflinePartition = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "partition_offline"),
+		"1 if Topic/Partition has no leader",
+		[]string{"topic", "partition"}, labels,
+	)
+
+	clusterUnderReplicatedPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cluster", "under_replicated_partitions"),
+		"Number of under Replicated partitions of the collected Topics",
+		nil, labels,
+	)
+
+	clusterUnderMinISRPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cluster", "under_min_isr_partitions"),
+		"Number of partitions of the collected Topics with less In-Sync Replicas than their min.insync.replicas",
+		nil, labels,
+	)
+
+	clusterOfflinePartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cluster", "offline_partitions"),
+		"Number of partitions of the collected Topics without leader",
+		nil, labels,
+	)
+
+	topicSize = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "size_bytes"),
+		"Size on disk of all the replicas of this Topic",
+		[]string{"topic"}, labels,
+	)
+
+	logDirOnline = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log_dir", "online"),
+		"1 if the log dir of the Broker is online, 0 if the Broker reports an error for it",
+		[]string{"broker", "log_dir"}, labels,
+	)
+
+	logDirPartitionSize = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log_dir", "partition_size_bytes"),
+		"Size on disk of the replica of Topic/Partition in the log dir of the Broker",
+		[]string{"broker", "log_dir", "topic", "partition"}, labels,
+	)
+
+	logDirPartitionOffsetLag = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "log_dir", "partition_offset_lag"),
+		"Lag of the replica of Topic/Partition in the log dir of the Broker behind the high watermark, or behind the current replica for a future replica",
+		[]string{"broker", "log_dir", "topic", "partition"}, labels,
+	)
+
+	topicConfig = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "config"),
+		"Value of a numeric config of this Topic",
+		[]string{"topic", "config"}, labels,
+	)
+
+	topicConfigInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "topic", "config_info"),
+		"Value of a non numeric config of this Topic, always 1",
+		[]string{"topic", "config", "value"}, labels,
+	)
+
 	consumergroupCurrentOffset = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "consumergroup", "current_offset"),
 		"Current Offset of a ConsumerGroup at Topic/Partition",
@@ -868,23 +1318,65 @@ func setup(
 		[]string{"consumergroup", "topic", "partition"}, labels,
 	)
 
+	consumergroupLagSeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "lag_seconds"),
+		"Approximate number of seconds a ConsumerGroup is behind at Topic/Partition",
+		[]string{"consumergroup", "topic", "partition"}, labels,
+	)
+
 	consumergroupLagZookeeper = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "consumergroupzookeeper", "lag_zookeeper"),
 		"Current Approximate Lag(zookeeper) of a ConsumerGroup at Topic/Partition",
-		[]string{"consumergroup", "topic", "partition"}, nil,
+		[]string{"consumergroup", "topic", "partition"}, labels,
 	)
 
-	//consumergroupLagSum = prometheus.NewDesc(
-	//	prometheus.BuildFQName(namespace, "consumergroup", "lag_sum"),
-	//	"Current Approximate Lag of a ConsumerGroup at Topic for all partitions",
-	//	[]string{"consumergroup", "topic"}, labels,
-	//)
+	consumergroupZookeeperMembers = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroupzookeeper", "members"),
+		"Amount of members of a ConsumerGroup registered in zookeeper",
+		[]string{"consumergroup"}, labels,
+	)
 
-	consumergroupLagSumRate = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace,"consumergroup","lag_sum_rate"),
-		"",
-		[]string{"consumergroup","topic","rate","time","timeDiff"},labels,
-		)
+	consumergroupZookeeperOwner = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroupzookeeper", "partition_owner_info"),
+		"Member of a ConsumerGroup owning the Topic/Partition in zookeeper, always 1",
+		[]string{"consumergroup", "topic", "partition", "owner"}, labels,
+	)
+
+	consumergroupLagSum = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "lag_sum"),
+		"Current Approximate Lag of a ConsumerGroup at Topic for all partitions",
+		[]string{"consumergroup", "topic"}, labels,
+	)
+
+	consumergroupConsumeRate = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "consume_rate"),
+		"Messages consumed per second by a ConsumerGroup at Topic since the previous scrape",
+		[]string{"consumergroup", "topic"}, labels,
+	)
+
+	consumergroupStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "status"),
+		"1 if the consumer group has this status, evaluated from its lag over the last scrapes, 0 otherwise",
+		[]string{"consumergroup", "status"}, labels,
+	)
+
+	consumergroupPartitionStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "partition_status"),
+		"Status of a ConsumerGroup at Topic/Partition, evaluated from its lag over the last scrapes: 0 OK, 1 WARN, 2 STALL, 3 STOP, 4 ERR",
+		[]string{"consumergroup", "topic", "partition"}, labels,
+	)
+
+	consumergroupMessagesConsumed = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "messages_consumed_total"),
+		"Number of messages consumed by a ConsumerGroup at Topic/Partition since the exporter started",
+		[]string{"consumergroup", "topic", "partition"}, labels,
+	)
+
+	consumergroupLagDrainSeconds = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "lag_drain_seconds"),
+		"Estimated seconds for a ConsumerGroup to consume its Lag at Topic at the current rates, +Inf if the Lag is not decreasing",
+		[]string{"consumergroup", "topic"}, labels,
+	)
 
 	consumergroupMembers = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "consumergroup", "members"),
@@ -892,32 +1384,141 @@ func setup(
 		[]string{"consumergroup"}, labels,
 	)
 
-	if logSarama {
-		sarama.Logger = log.New(os.Stdout, "[sarama] ", log.LstdFlags)
-	}
+	consumergroupState = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "state"),
+		"1 if the consumer group is in this state, 0 otherwise",
+		[]string{"consumergroup", "state"}, labels,
+	)
 
-	exporter, err := NewExporter(opts, topicFilter, groupFilter)
-	if err != nil {
-		glog.Fatalln(err)
-	}
-	defer exporter.client.Close()
-	prometheus.MustRegister(exporter)
+	consumergroupInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "info"),
+		"Protocol type and assignment strategy of a consumer group, always 1",
+		[]string{"consumergroup", "protocol_type", "protocol"}, labels,
+	)
 
-	http.Handle(metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html>
-	        <head><title>Kafka Exporter</title></head>
-	        <body>
-	        <h1>Kafka Exporter</h1>
-	        <p><a href='` + metricsPath + `'>Metrics</a></p>
-	        </body>
-	        </html>`))
-	})
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		// need more specific sarama check
-		w.Write([]byte("ok"))
-	})
+	consumergroupMemberPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "member_assigned_partitions"),
+		"Number of partitions assigned to the members of a consumer group with this client id and host",
+		[]string{"consumergroup", "client_id", "client_host"}, labels,
+	)
 
-	glog.Infoln("Listening on", listenAddress)
-	glog.Fatal(http.ListenAndServe(listenAddress, nil))
+	consumergroupUnassignedPartitions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "unassigned_partitions"),
+		"Number of partitions with a committed offset of a consumer group that are assigned to none of its members",
+		[]string{"consumergroup"}, labels,
+	)
+
+	consumergroupCommittedTimestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "committed_message_timestamp_seconds"),
+		"Unix timestamp of the record at the Offset committed by a ConsumerGroup at Topic/Partition, i.e. the next record it consumes",
+		[]string{"consumergroup", "topic", "partition"}, labels,
+	)
+
+	consumergroupCoordinator = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "consumergroup", "coordinator"),
+		"Broker coordinating a consumer group, always 1",
+		[]string{"consumergroup", "broker"}, labels,
+	)
+
+	exporterLastScrapeTimestamp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "last_scrape_timestamp_seconds"),
+		"Unix timestamp of the end of the last scrape of Kafka",
+		nil, labels,
+	)
+
+	exporterScrapeDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_duration_seconds"),
+		"Duration of the last scrape of Kafka",
+		nil, labels,
+	)
+
+	exporterScrapePhaseDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_phase_duration_seconds"),
+		"Duration of a phase of the last scrape of Kafka",
+		[]string{"phase"}, labels,
+	)
+
+	exporterScrapeErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "scrape_errors_total"),
+		"Number of errors that made a scrape phase skip some metrics",
+		[]string{"phase"}, labels,
+	)
+
+	exporterRequests = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "kafka_requests_total"),
+		"Number of requests of a Kafka API sent to a broker by the scrapes",
+		[]string{"api", "broker"}, labels,
+	)
+
+	exporterRequestErrors = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "kafka_request_errors_total"),
+		"Number of requests of a Kafka API sent to a broker by the scrapes that failed",
+		[]string{"api", "broker"}, labels,
+	)
+
+	exporterFilteredTopics = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "filtered_topics"),
+		"Number of topics skipped by the topic filter during the last scrape",
+		nil, labels,
+	)
+
+	exporterFilteredGroups = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "filtered_consumergroups"),
+		"Number of consumer groups skipped by the group filter during the last scrape",
+		nil, labels,
+	)
+
+	exporterClusterUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "cluster_up"),
+		"Whether the Kafka client of a cluster of the config file is connected, its other metrics are only exported once it is",
+		nil, labels,
+	)
+
+	clientIncomingBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_incoming_bytes_total"),
+		"Number of bytes read from a broker by the Kafka client",
+		[]string{"broker"}, labels,
+	)
+
+	clientOutgoingBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_outgoing_bytes_total"),
+		"Number of bytes written to a broker by the Kafka client",
+		[]string{"broker"}, labels,
+	)
+
+	clientRequests = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_requests_total"),
+		"Number of requests sent to a broker by the Kafka client",
+		[]string{"broker"}, labels,
+	)
+
+	clientResponses = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_responses_total"),
+		"Number of responses received from a broker by the Kafka client",
+		[]string{"broker"}, labels,
+	)
+
+	clientRequestsInFlight = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_requests_in_flight"),
+		"Number of requests sent to a broker by the Kafka client awaiting a response",
+		[]string{"broker"}, labels,
+	)
+
+	clientRequestSize = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_request_size_bytes"),
+		"Quantiles of the size of the recent requests sent to a broker by the Kafka client",
+		[]string{"broker", "quantile"}, labels,
+	)
+
+	clientResponseSize = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_response_size_bytes"),
+		"Quantiles of the size of the recent responses received from a broker by the Kafka client",
+		[]string{"broker", "quantile"}, labels,
+	)
+
+	clientRequestLatency = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "exporter", "client_request_latency_seconds"),
+		"Quantiles of the latency of the recent requests sent to a broker by the Kafka client",
+		[]string{"broker", "quantile"}, labels,
+	)
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var descNamePattern = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// exporterTestDescs creates the descs of the exporter, without constant
+// labels, and returns their names for collectTestMetrics.
+func exporterTestDescs() map[*prometheus.Desc]string {
+	initDescs(nil)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		(&Exporter{}).Describe(ch)
+		close(ch)
+	}()
+	descs := make(map[*prometheus.Desc]string)
+	for desc := range ch {
+		descs[desc] = descNamePattern.FindStringSubmatch(desc.String())[1]
+	}
+	return descs
+}
+
+// newTestCluster starts the brokers 1, the controller, and 2 of a cluster
+// holding the topic orders, whose min.insync.replicas is 2:
+//   - partition 0 is led by broker 1 and replicated in sync by both brokers,
+//   - partition 1 has no leader,
+//   - partition 2 is led by broker 2, the only in-sync replica.
+func newTestCluster(t *testing.T) (*sarama.MockBroker, *sarama.MockBroker) {
+	first := sarama.NewMockBroker(t, 1)
+	second := sarama.NewMockBroker(t, 2)
+
+	metadata := &sarama.MetadataResponse{Version: 5, ControllerID: first.BrokerID()}
+	metadata.AddBroker(first.Addr(), first.BrokerID())
+	metadata.AddBroker(second.Addr(), second.BrokerID())
+	metadata.AddTopicPartition("orders", 0, 1, []int32{1, 2}, []int32{1, 2}, nil, sarama.ErrNoError)
+	metadata.AddTopicPartition("orders", 1, -1, []int32{1, 2}, []int32{}, nil, sarama.ErrLeaderNotAvailable)
+	metadata.AddTopicPartition("orders", 2, 2, []int32{2, 1}, []int32{2}, nil, sarama.ErrNoError)
+	apiVersions := sarama.NewMockWrapper(&sarama.ApiVersionsResponse{
+		ApiVersions: []*sarama.ApiVersionsResponseBlock{
+			{ApiKey: apiKeyOffsetFetch, MaxVersion: 4},
+			{ApiKey: 32, MaxVersion: 2}, // DescribeConfigs, as of Kafka 2.0
+		},
+	})
+
+	first.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":    sarama.NewMockWrapper(metadata),
+		"ApiVersionsRequest": apiVersions,
+		"DescribeConfigsRequest": sarama.NewMockWrapper(&sarama.DescribeConfigsResponse{
+			Version: 2,
+			Resources: []*sarama.ResourceResponse{{
+				Type:    sarama.TopicResource,
+				Name:    "orders",
+				Configs: []*sarama.ConfigEntry{{Name: minInsyncReplicasConfig, Value: "2"}},
+			}},
+		}),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 0, sarama.OffsetOldest, 2),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t),
+	})
+	second.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest":    sarama.NewMockWrapper(metadata),
+		"ApiVersionsRequest": apiVersions,
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).SetVersion(1).
+			SetOffset("orders", 2, sarama.OffsetNewest, 30).
+			SetOffset("orders", 2, sarama.OffsetOldest, 0),
+		"ListGroupsRequest": sarama.NewMockListGroupsResponse(t),
+	})
+	return first, second
+}
+
+// newTestExporter returns an Exporter of the cluster of seed, not started.
+func newTestExporter(t *testing.T, seed *sarama.MockBroker, opts kafkaOpts) *Exporter {
+	cfg := testDefaults()
+	cfg.Brokers = []string{seed.Addr()}
+	e, err := newExporter(cfg.kafkaOpts(opts), ".*", ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCollectMetadataMetrics(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	defer second.Close()
+	descs := exporterTestDescs()
+	e := newTestExporter(t, first, kafkaOpts{})
+	defer e.Close()
+
+	metrics := collectTestMetrics(t, descs, e.collect)
+
+	for key, expected := range map[string]float64{
+		`kafka_brokers{}`:                        2,
+		`kafka_topic_partitions{topic="orders"}`: 3,
+		// The partition without leader is offline, under-replicated and
+		// under min.insync.replicas.
+		`kafka_topic_partition_leader{partition="0",topic="orders"}`:                     1,
+		`kafka_topic_partition_leader{partition="2",topic="orders"}`:                     2,
+		`kafka_topic_partition_offline{partition="0",topic="orders"}`:                    0,
+		`kafka_topic_partition_offline{partition="1",topic="orders"}`:                    1,
+		`kafka_topic_partition_offline{partition="2",topic="orders"}`:                    0,
+		`kafka_topic_partition_replicas{partition="1",topic="orders"}`:                   2,
+		`kafka_topic_partition_in_sync_replica{partition="1",topic="orders"}`:            0,
+		`kafka_topic_partition_in_sync_replica{partition="2",topic="orders"}`:            1,
+		`kafka_topic_partition_leader_is_preferred{partition="0",topic="orders"}`:        1,
+		`kafka_topic_partition_leader_is_preferred{partition="1",topic="orders"}`:        0,
+		`kafka_topic_partition_under_replicated_partition{partition="0",topic="orders"}`: 0,
+		`kafka_topic_partition_under_replicated_partition{partition="1",topic="orders"}`: 1,
+		`kafka_topic_partition_under_replicated_partition{partition="2",topic="orders"}`: 1,
+		`kafka_topic_partition_under_min_isr{partition="0",topic="orders"}`:              0,
+		`kafka_topic_partition_under_min_isr{partition="1",topic="orders"}`:              1,
+		`kafka_topic_partition_under_min_isr{partition="2",topic="orders"}`:              1,
+		`kafka_topic_partition_current_offset{partition="0",topic="orders"}`:             10,
+		`kafka_topic_partition_current_offset{partition="2",topic="orders"}`:             30,
+		`kafka_topic_partition_oldest_offset{partition="0",topic="orders"}`:              2,
+		`kafka_cluster_under_replicated_partitions{}`:                                    2,
+		`kafka_cluster_offline_partitions{}`:                                             1,
+		`kafka_cluster_under_min_isr_partitions{}`:                                       2,
+		`kafka_broker_leader_partitions{id="1"}`:                                         1,
+		`kafka_broker_leader_partitions{id="2"}`:                                         1,
+		`kafka_broker_replica_partitions{id="1"}`:                                        3,
+		`kafka_broker_replica_partitions{id="2"}`:                                        3,
+		`kafka_exporter_filtered_topics{}`:                                               0,
+	} {
+		if value, ok := metrics[key]; !ok || value != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", key, expected, value, ok)
+		}
+	}
+	if _, ok := metrics[`kafka_topic_partition_leader{partition="1",topic="orders"}`]; ok {
+		t.Error("expected no leader for the offline partition")
+	}
+	if _, ok := metrics[`kafka_topic_partition_current_offset{partition="1",topic="orders"}`]; ok {
+		t.Error("expected no offset for the offline partition")
+	}
+	if errors := e.stats.errors[phaseMetadata]; errors != 0 {
+		t.Errorf("expected the offline partition not to be counted as an error, got %v", errors)
+	}
+}
+
+func TestScrapeLoop(t *testing.T) {
+	first, second := newTestCluster(t)
+	defer first.Close()
+	defer second.Close()
+	descs := exporterTestDescs()
+	cfg := testDefaults()
+	cfg.Brokers = []string{first.Addr()}
+	cfg.ScrapeInterval = "1h"
+	e, err := newExporter(cfg.kafkaOpts(kafkaOpts{}), ".*", ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.start()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		e.snapshotMu.RLock()
+		scraped := len(e.snapshot) > 0
+		e.snapshotMu.RUnlock()
+		if scraped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a background scrape")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	requests := len(first.History()) + len(second.History())
+	metrics := collectTestMetrics(t, descs, e.Collect)
+	again := collectTestMetrics(t, descs, e.Collect)
+	if served := len(first.History()) + len(second.History()); served != requests {
+		t.Errorf("expected Collect to serve the snapshot without requests to Kafka, got %d requests", served-requests)
+	}
+	if value := metrics[`kafka_brokers{}`]; value != 2 {
+		t.Errorf("expected the brokers of the snapshot, got %v", value)
+	}
+	timestamp, ok := metrics[`kafka_exporter_last_scrape_timestamp_seconds{}`]
+	if !ok || timestamp <= 0 {
+		t.Errorf("expected the timestamp of the scrape, got %v (%t)", timestamp, ok)
+	}
+	if again[`kafka_exporter_last_scrape_timestamp_seconds{}`] != timestamp {
+		t.Errorf("expected the same scrape to be served twice, got %v and %v", timestamp, again[`kafka_exporter_last_scrape_timestamp_seconds{}`])
+	}
+	if _, ok := metrics[`kafka_exporter_scrape_duration_seconds{}`]; !ok {
+		t.Error("expected the duration of the scrape")
+	}
+
+	closed := make(chan error)
+	go func() { closed <- e.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to stop the scrape loop")
+	}
+	select {
+	case <-e.loopDone:
+	default:
+		t.Error("expected the scrape loop to be done")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+// kafkaVersionAuto makes the exporter detect the Kafka version of the cluster
+// from the APIs its brokers support.
+const kafkaVersionAuto = "auto"
+
+// kafkaVersionSignatures lists, for every Kafka version sarama knows, an API
+// version first supported by that release. They are ordered from the newest
+// Kafka version to the oldest.
+var kafkaVersionSignatures = []struct {
+	version    sarama.KafkaVersion
+	apiKey     int16
+	apiVersion int16
+}{
+	{sarama.V2_8_0_0, 60, 0},  // DescribeCluster
+	{sarama.V2_7_0_0, 50, 0},  // DescribeUserScramCredentials
+	{sarama.V2_6_0_0, 48, 0},  // DescribeClientQuotas
+	{sarama.V2_5_0_0, 9, 7},   // OffsetFetch
+	{sarama.V2_4_0_0, 47, 0},  // OffsetDelete
+	{sarama.V2_3_0_0, 44, 0},  // IncrementalAlterConfigs
+	{sarama.V2_2_0_0, 43, 0},  // ElectLeaders
+	{sarama.V2_1_0_0, 1, 10},  // Fetch
+	{sarama.V2_0_0_0, 32, 2},  // DescribeConfigs
+	{sarama.V1_1_0_0, 38, 0},  // CreateDelegationToken
+	{sarama.V1_0_0_0, 35, 0},  // DescribeLogDirs
+	{sarama.V0_11_0_0, 32, 0}, // DescribeConfigs
+	{sarama.V0_10_2_0, 9, 2},  // OffsetFetch
+	{sarama.V0_10_1_0, 19, 0}, // CreateTopics
+	{sarama.V0_10_0_0, 18, 0}, // ApiVersions
+}
+
+// kafkaVersionOf returns the newest Kafka version whose APIs are supported,
+// given the highest version of each API supported by a broker. Newer brokers
+// are reported as the newest version sarama knows.
+func kafkaVersionOf(versions map[int16]int16) sarama.KafkaVersion {
+	for _, signature := range kafkaVersionSignatures {
+		if version, ok := versions[signature.apiKey]; ok && version >= signature.apiVersion {
+			return signature.version
+		}
+	}
+	return sarama.V0_10_0_0
+}
+
+// detectKafkaVersion sends ApiVersions to the bootstrap brokers and returns
+// the newest Kafka version supported by all of them, which is the oldest
+// broker version during a rolling upgrade. The unreachable brokers are
+// skipped. Brokers older than 0.10 do not support ApiVersions, their version
+// must be given explicitly.
+func detectKafkaVersion(addrs []string, config *sarama.Config) (sarama.KafkaVersion, error) {
+	detectConfig := *config
+	// ApiVersions is supported since 0.10, and version 0 of the request by
+	// every newer broker.
+	detectConfig.Version = sarama.V0_10_0_0
+
+	var (
+		detected sarama.KafkaVersion
+		found    bool
+		lastErr  error
+	)
+	for _, addr := range addrs {
+		version, err := brokerKafkaVersion(addr, &detectConfig)
+		if err != nil {
+			glog.Errorf("Cannot detect Kafka version of broker %s: %v", addr, err)
+			lastErr = err
+			continue
+		}
+		glog.Infof("Broker %s supports Kafka %s", addr, version)
+		if !found || !version.IsAtLeast(detected) {
+			detected = version
+			found = true
+		}
+	}
+	if !found {
+		return detected, fmt.Errorf("cannot detect Kafka version from any broker, set kafka.version explicitly: %v", lastErr)
+	}
+	return detected, nil
+}
+
+func brokerKafkaVersion(addr string, config *sarama.Config) (sarama.KafkaVersion, error) {
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(config); err != nil {
+		return sarama.KafkaVersion{}, err
+	}
+	defer broker.Close()
+
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return sarama.KafkaVersion{}, err
+	}
+	if response.Err != sarama.ErrNoError {
+		return sarama.KafkaVersion{}, response.Err
+	}
+	return kafkaVersionOf(maxAPIVersions(response)), nil
+}
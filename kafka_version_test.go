@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func mockAPIVersionsBroker(t *testing.T, id int32, versions map[int16]int16) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, id)
+	response := &sarama.ApiVersionsResponse{}
+	for apiKey, maxVersion := range versions {
+		response.ApiVersions = append(response.ApiVersions, &sarama.ApiVersionsResponseBlock{ApiKey: apiKey, MaxVersion: maxVersion})
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"ApiVersionsRequest": sarama.NewMockWrapper(response),
+	})
+	return broker
+}
+
+func TestDetectKafkaVersion(t *testing.T) {
+	upgraded := mockAPIVersionsBroker(t, 1, map[int16]int16{1: 12, 9: 7, 18: 3, 32: 4, 43: 2, 44: 1, 47: 0, 48: 1, 50: 0, 60: 0})
+	defer upgraded.Close()
+	old := mockAPIVersionsBroker(t, 2, map[int16]int16{1: 10, 9: 5, 18: 2, 32: 2, 43: 2, 44: 1})
+	defer old.Close()
+
+	version, err := detectKafkaVersion([]string{upgraded.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != sarama.V2_8_0_0 {
+		t.Errorf("expected %s, got %s", sarama.V2_8_0_0, version)
+	}
+
+	// During a rolling upgrade the oldest broker wins, unreachable brokers are skipped.
+	version, err = detectKafkaVersion([]string{"localhost:0", upgraded.Addr(), old.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != sarama.V2_3_0_0 {
+		t.Errorf("expected %s, got %s", sarama.V2_3_0_0, version)
+	}
+
+	if _, err := detectKafkaVersion([]string{"localhost:0"}, sarama.NewConfig()); err == nil {
+		t.Error("expected an error without reachable broker")
+	}
+}
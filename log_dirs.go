@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectLogDirMetrics exports the state of the log dirs of every broker and
+// the size on disk of the replicas of the given partitions, as reported by
+// DescribeLogDirs. The brokers are queried concurrently.
+func (e *Exporter) collectLogDirMetrics(ch chan<- prometheus.Metric, topicsPartitions map[string][]int32) {
+	if len(topicsPartitions) == 0 {
+		// An empty request would describe every topic.
+		return
+	}
+
+	request := &sarama.DescribeLogDirsRequest{}
+	if e.client.Config().Version.IsAtLeast(sarama.V2_0_0_0) {
+		request.Version = 1
+	}
+	for topic, partitions := range topicsPartitions {
+		request.DescribeTopics = append(request.DescribeTopics, sarama.DescribeLogDirsRequestTopic{
+			Topic:        topic,
+			PartitionIDs: partitions,
+		})
+	}
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		topicSizes = make(map[string]int64, len(topicsPartitions))
+	)
+	for _, broker := range e.client.Brokers() {
+		wg.Add(1)
+		go func(broker *sarama.Broker) {
+			defer wg.Done()
+
+			if err := broker.Open(e.client.Config()); err != nil && err != sarama.ErrAlreadyConnected {
+				e.scrapeErrorf(phaseLogDirs, "Cannot connect to broker %d: %v", broker.ID(), err)
+				return
+			}
+			response, err := broker.DescribeLogDirs(request)
+			e.stats.request("DescribeLogDirs", broker, err)
+			if err != nil {
+				e.scrapeErrorf(phaseLogDirs, "Cannot describe log dirs of broker %d: %v", broker.ID(), err)
+				return
+			}
+
+			id := strconv.Itoa(int(broker.ID()))
+			for _, logDir := range response.LogDirs {
+				online := 1
+				if logDir.ErrorCode != sarama.ErrNoError {
+					e.scrapeErrorf(phaseLogDirs, "Log dir %s of broker %d is not available: %v", logDir.Path, broker.ID(), logDir.ErrorCode)
+					online = 0
+				}
+				ch <- prometheus.MustNewConstMetric(
+					logDirOnline, prometheus.GaugeValue, float64(online), id, logDir.Path,
+				)
+
+				for _, topic := range logDir.Topics {
+					for _, partition := range topic.Partitions {
+						if !partition.IsTemporary {
+							mu.Lock()
+							topicSizes[topic.Topic] += partition.Size
+							mu.Unlock()
+						}
+						ch <- prometheus.MustNewConstMetric(
+							logDirPartitionSize, prometheus.GaugeValue, float64(partition.Size), id, logDir.Path, topic.Topic, strconv.FormatInt(int64(partition.PartitionID), 10),
+						)
+						ch <- prometheus.MustNewConstMetric(
+							logDirPartitionOffsetLag, prometheus.GaugeValue, float64(partition.OffsetLag), id, logDir.Path, topic.Topic, strconv.FormatInt(int64(partition.PartitionID), 10),
+						)
+					}
+				}
+			}
+		}(broker)
+	}
+	wg.Wait()
+
+	for topic, size := range topicSizes {
+		ch <- prometheus.MustNewConstMetric(
+			topicSize, prometheus.GaugeValue, float64(size), topic,
+		)
+	}
+}
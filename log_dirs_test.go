@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testDesc returns a desc for the tests exporting metrics, the descs of the
+// exporter being created by setup.
+func testDesc(name string, variableLabels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(name, name, variableLabels, nil)
+}
+
+// collectTestMetrics runs collect and returns the value of the metrics it
+// exported, keyed by the name of their desc followed by their labels, e.g.
+// `size{topic="orders"}`.
+func collectTestMetrics(t *testing.T, descs map[*prometheus.Desc]string, collect func(ch chan<- prometheus.Metric)) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	values := make(map[string]float64)
+	go func() {
+		defer close(done)
+		for metric := range ch {
+			var m dto.Metric
+			if err := metric.Write(&m); err != nil {
+				t.Error(err)
+				continue
+			}
+			var labels []string
+			for _, label := range m.Label {
+				labels = append(labels, label.GetName()+`="`+label.GetValue()+`"`)
+			}
+			sort.Strings(labels)
+			key := descs[metric.Desc()] + "{" + strings.Join(labels, ",") + "}"
+			if _, ok := values[key]; ok {
+				t.Errorf("%s was exported more than once", key)
+			}
+			switch {
+			case m.Gauge != nil:
+				values[key] = m.Gauge.GetValue()
+			case m.Counter != nil:
+				values[key] = m.Counter.GetValue()
+			}
+		}
+	}()
+	collect(ch)
+	close(ch)
+	<-done
+	return values
+}
+
+func TestCollectLogDirMetrics(t *testing.T) {
+	first := sarama.NewMockBroker(t, 1)
+	defer first.Close()
+	second := sarama.NewMockBroker(t, 2)
+	defer second.Close()
+
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(first.Addr(), first.BrokerID()).
+		SetBroker(second.Addr(), second.BrokerID())
+	first.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"DescribeLogDirsRequest": sarama.NewMockWrapper(&sarama.DescribeLogDirsResponse{
+			Version: 1,
+			LogDirs: []sarama.DescribeLogDirsResponseDirMetadata{
+				{
+					Path: "/data/1",
+					Topics: []sarama.DescribeLogDirsResponseTopic{{
+						Topic: "orders",
+						Partitions: []sarama.DescribeLogDirsResponsePartition{
+							{PartitionID: 0, Size: 100},
+							{PartitionID: 1, Size: 200, OffsetLag: 5},
+						},
+					}},
+				},
+				{Path: "/data/2", ErrorCode: sarama.ErrKafkaStorageError},
+			},
+		}),
+	})
+	second.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": metadata,
+		"DescribeLogDirsRequest": sarama.NewMockWrapper(&sarama.DescribeLogDirsResponse{
+			Version: 1,
+			LogDirs: []sarama.DescribeLogDirsResponseDirMetadata{{
+				Path: "/data/1",
+				Topics: []sarama.DescribeLogDirsResponseTopic{{
+					Topic: "orders",
+					Partitions: []sarama.DescribeLogDirsResponsePartition{
+						{PartitionID: 0, Size: 100},
+						// A replica being moved to this log dir.
+						{PartitionID: 1, Size: 50, OffsetLag: 150, IsTemporary: true},
+					},
+				}},
+			}},
+		}),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{first.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	logDirOnline = testDesc("online", "broker", "log_dir")
+	logDirPartitionSize = testDesc("size", "broker", "log_dir", "topic", "partition")
+	logDirPartitionOffsetLag = testDesc("offset_lag", "broker", "log_dir", "topic", "partition")
+	topicSize = testDesc("topic_size", "topic")
+	descs := map[*prometheus.Desc]string{
+		logDirOnline:             "online",
+		logDirPartitionSize:      "size",
+		logDirPartitionOffsetLag: "offset_lag",
+		topicSize:                "topic_size",
+	}
+
+	e := &Exporter{client: client}
+	metrics := collectTestMetrics(t, descs, func(ch chan<- prometheus.Metric) {
+		e.collectLogDirMetrics(ch, map[string][]int32{"orders": {0, 1}})
+	})
+
+	for key, expected := range map[string]float64{
+		`online{broker="1",log_dir="/data/1"}`:                                  1,
+		`online{broker="1",log_dir="/data/2"}`:                                  0,
+		`online{broker="2",log_dir="/data/1"}`:                                  1,
+		`size{broker="1",log_dir="/data/1",partition="1",topic="orders"}`:       200,
+		`size{broker="2",log_dir="/data/1",partition="1",topic="orders"}`:       50,
+		`offset_lag{broker="1",log_dir="/data/1",partition="1",topic="orders"}`: 5,
+		`offset_lag{broker="2",log_dir="/data/1",partition="1",topic="orders"}`: 150,
+		// The temporary replica is not counted in the size of the topic.
+		`topic_size{topic="orders"}`: 400,
+	} {
+		if value, ok := metrics[key]; !ok || value != expected {
+			t.Errorf("%s: expected %v, got %v (%t)", key, expected, value, ok)
+		}
+	}
+	if errors := e.stats.errors[phaseLogDirs]; errors != 1 {
+		t.Errorf("expected the offline log dir to be counted as an error, got %v", errors)
+	}
+}
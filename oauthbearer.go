@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+// tokenTimeout bounds the requests to the token endpoint and the token
+// commands.
+const tokenTimeout = 30 * time.Second
+
+// oauthToken is an OAuth access token, which does not expire when expiry is
+// zero.
+type oauthToken struct {
+	value  string
+	expiry time.Time
+}
+
+// tokenSource fetches the OAUTHBEARER access tokens of the exporter.
+type tokenSource interface {
+	token() (oauthToken, error)
+}
+
+// tokenProvider implements sarama.AccessTokenProvider. It keeps the token of
+// its source until most of its lifetime has passed, so that brokers are never
+// given a token about to expire. Tokens without expiry are fetched again
+// every time the client authenticates to a broker.
+type tokenProvider struct {
+	source tokenSource
+
+	mu        sync.Mutex
+	current   oauthToken
+	refreshAt time.Time
+}
+
+// Token implements sarama.AccessTokenProvider.
+func (p *tokenProvider) Token() (*sarama.AccessToken, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.current.value != "" && !p.current.expiry.IsZero() && now.Before(p.refreshAt) {
+		return &sarama.AccessToken{Token: p.current.value}, nil
+	}
+	token, err := p.source.token()
+	if err != nil {
+		if p.current.value != "" && now.Before(p.current.expiry) {
+			glog.Errorf("Cannot refresh OAuth token, using the current one until it expires at %v: %v", p.current.expiry, err)
+			return &sarama.AccessToken{Token: p.current.value}, nil
+		}
+		return nil, fmt.Errorf("cannot get OAuth token: %v", err)
+	}
+	p.current = token
+	// Refresh once 80% of the lifetime has passed.
+	p.refreshAt = now.Add(token.expiry.Sub(now) * 4 / 5)
+	return &sarama.AccessToken{Token: token.value}, nil
+}
+
+// newTokenProvider returns the token provider of the single token source
+// given in opts.
+func newTokenProvider(opts kafkaOpts) (*tokenProvider, error) {
+	var sources []tokenSource
+	if opts.oauthTokenURL != "" {
+		if opts.oauthClientID == "" {
+			return nil, errors.New("the OAuth client ID is required with a token URL")
+		}
+		sources = append(sources, &clientCredentialsSource{
+			tokenURL:     opts.oauthTokenURL,
+			clientID:     opts.oauthClientID,
+			clientSecret: opts.oauthClientSecret,
+			scopes:       opts.oauthScopes,
+			client:       &http.Client{Timeout: tokenTimeout},
+		})
+	}
+	if opts.oauthTokenFile != "" {
+		sources = append(sources, &fileTokenSource{path: opts.oauthTokenFile})
+	}
+	if opts.oauthTokenCommand != "" {
+		sources = append(sources, &commandTokenSource{args: strings.Fields(opts.oauthTokenCommand)})
+	}
+	if len(sources) != 1 {
+		return nil, errors.New("oauthbearer requires exactly one of a token URL, a token file or a token command")
+	}
+	return &tokenProvider{source: sources[0]}, nil
+}
+
+// clientCredentialsSource requests tokens from an OAuth token endpoint with
+// the client credentials grant.
+type clientCredentialsSource struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+}
+
+func (s *clientCredentialsSource) token() (oauthToken, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return oauthToken{}, fmt.Errorf("token endpoint returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return parseTokenResponse(body)
+}
+
+// fileTokenSource reads the token from a file, kept up to date by another
+// process. The file is only read again when it changes.
+type fileTokenSource struct {
+	path string
+
+	modTime time.Time
+	size    int64
+	current oauthToken
+}
+
+// token is only called by the tokenProvider, under its lock.
+func (s *fileTokenSource) token() (oauthToken, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	if s.current.value != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.current, nil
+	}
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return oauthToken{}, err
+	}
+	token, err := parseToken(content)
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("token file %s: %v", s.path, err)
+	}
+	s.modTime, s.size, s.current = info.ModTime(), info.Size(), token
+	return token, nil
+}
+
+// commandTokenSource runs a command printing the token on its standard
+// output.
+type commandTokenSource struct {
+	args []string
+}
+
+func (s *commandTokenSource) token() (oauthToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.args[0], s.args[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("token command failed: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return parseToken(output)
+}
+
+// parseToken parses the output of a token file or command, either the token
+// itself or a token endpoint response in JSON.
+func parseToken(content []byte) (oauthToken, error) {
+	content = bytes.TrimSpace(content)
+	if bytes.HasPrefix(content, []byte("{")) {
+		return parseTokenResponse(content)
+	}
+	if len(content) == 0 {
+		return oauthToken{}, errors.New("empty token")
+	}
+	value := string(content)
+	return oauthToken{value: value, expiry: jwtExpiry(value)}, nil
+}
+
+// parseTokenResponse parses the JSON response of a token endpoint. Without
+// expires_in, the expiry is read from the token when it is a JWT.
+func parseTokenResponse(content []byte) (oauthToken, error) {
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(content, &response); err != nil {
+		return oauthToken{}, fmt.Errorf("invalid token response: %v", err)
+	}
+	if response.AccessToken == "" {
+		return oauthToken{}, errors.New("no access_token in the token response")
+	}
+	token := oauthToken{value: response.AccessToken, expiry: jwtExpiry(response.AccessToken)}
+	if response.ExpiresIn > 0 {
+		token.expiry = time.Now().Add(time.Duration(response.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// jwtExpiry returns the exp claim of the token when it is a JWT, or zero.
+// The signature is not verified, the brokers do.
+func jwtExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCredentialsTokenProvider(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if id, secret, _ := r.BasicAuth(); id != "exporter" || secret != "secret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "kafka metrics" {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, requests)
+	}))
+	defer server.Close()
+
+	p, err := newTokenProvider(kafkaOpts{
+		oauthTokenURL:     server.URL,
+		oauthClientID:     "exporter",
+		oauthClientSecret: "secret",
+		oauthScopes:       []string{"kafka", "metrics"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		token, err := p.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Token != "token-1" {
+			t.Errorf("expected cached token-1, got %s", token.Token)
+		}
+	}
+
+	// Refresh once most of the lifetime has passed.
+	p.refreshAt = time.Now().Add(-time.Second)
+	if token, err := p.Token(); err != nil || token.Token != "token-2" {
+		t.Errorf("expected refreshed token-2, got %v, %v", token, err)
+	}
+
+	// Keep the current token while the endpoint fails.
+	p.source.(*clientCredentialsSource).clientSecret = "wrong"
+	p.refreshAt = time.Now().Add(-time.Second)
+	if token, err := p.Token(); err != nil || token.Token != "token-2" {
+		t.Errorf("expected current token-2, got %v, %v", token, err)
+	}
+}
+
+func TestFileTokenProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	p, err := newTokenProvider(kafkaOpts{oauthTokenFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{"first\n", "second-token\n"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		token, err := p.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := content[:len(content)-1]; token.Token != expected {
+			t.Errorf("expected %s, got %s", expected, token.Token)
+		}
+	}
+}
+
+func TestCommandTokenProvider(t *testing.T) {
+	p, err := newTokenProvider(kafkaOpts{oauthTokenCommand: `echo {"access_token":"from-command","expires_in":60}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := p.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "from-command" {
+		t.Errorf("expected from-command, got %s", token.Token)
+	}
+	if p.current.expiry.IsZero() {
+		t.Error("expected the token to expire")
+	}
+}
+
+func TestNewTokenProviderErrors(t *testing.T) {
+	for _, opts := range []kafkaOpts{
+		{},
+		{oauthTokenURL: "http://localhost/token"},
+		{oauthTokenFile: "/run/token", oauthTokenCommand: "get-token"},
+	} {
+		if _, err := newTokenProvider(opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"exporter","exp":1700000000}`))
+	if expiry := jwtExpiry("header." + payload + ".signature"); !expiry.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expected the exp claim, got %v", expiry)
+	}
+	if expiry := jwtExpiry("opaque-token"); !expiry.IsZero() {
+		t.Errorf("expected no expiry for an opaque token, got %v", expiry)
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// OffsetFetch v8 (KIP-709) fetches the offsets of several groups at once.
+// sarama does not support it and cannot send requests it does not define,
+// so the request is sent over a connection of its own, authenticated with
+// the SASL settings of the client.
+const (
+	offsetFetchGroupsVersion int16 = 8
+	apiKeySaslHandshake      int16 = 17
+	apiKeySaslAuthenticate   int16 = 36
+)
+
+var errTruncatedResponse = errors.New("truncated response")
+
+// offsetFetchGroup is a group of an OffsetFetch v8 request, with the
+// partitions whose offsets are fetched, or nil to fetch all the committed
+// offsets of the group.
+type offsetFetchGroup struct {
+	groupID string
+	topics  map[string][]int32
+}
+
+// offsetFetchGroupsSupported tells whether OffsetFetch v8 can be sent with
+// the settings of the client. GSSAPI and proxies are only supported by
+// sarama itself.
+func offsetFetchGroupsSupported(config *sarama.Config) bool {
+	if config.Net.Proxy.Enable {
+		return false
+	}
+	if !config.Net.SASL.Enable {
+		return true
+	}
+	switch config.Net.SASL.Mechanism {
+	case sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, sarama.SASLTypeOAuth:
+		return config.Net.SASL.Handshake
+	}
+	return false
+}
+
+// encodeOffsetFetchGroupsRequest returns the body of an OffsetFetch v8
+// request.
+func encodeOffsetFetchGroupsRequest(groups []offsetFetchGroup) []byte {
+	var e kafkaEncoder
+	e.compactArrayLength(len(groups))
+	for _, group := range groups {
+		e.compactString(group.groupID)
+		if group.topics == nil {
+			e.compactArrayLength(-1)
+		} else {
+			topics := make([]string, 0, len(group.topics))
+			for topic := range group.topics {
+				topics = append(topics, topic)
+			}
+			sort.Strings(topics)
+			e.compactArrayLength(len(topics))
+			for _, topic := range topics {
+				e.compactString(topic)
+				e.compactArrayLength(len(group.topics[topic]))
+				for _, partition := range group.topics[topic] {
+					e.int32(partition)
+				}
+				e.emptyTaggedFields()
+			}
+		}
+		e.emptyTaggedFields()
+	}
+	e.int8(0) // require_stable
+	e.emptyTaggedFields()
+	return e.Bytes()
+}
+
+// decodeOffsetFetchGroupsResponse returns the offsets of the groups of an
+// OffsetFetch v8 response body.
+func decodeOffsetFetchGroupsResponse(body []byte) (map[string]groupOffsets, error) {
+	d := kafkaDecoder{buf: body}
+	d.int32() // throttle_time_ms
+	groups := make(map[string]groupOffsets)
+	for i, n := 0, d.compactArrayLength(); i < n && d.err == nil; i++ {
+		groupID := d.compactString()
+		blocks := make(map[string]map[int32]*sarama.OffsetFetchResponseBlock)
+		for j, topics := 0, d.compactArrayLength(); j < topics && d.err == nil; j++ {
+			topic := d.compactString()
+			blocks[topic] = make(map[int32]*sarama.OffsetFetchResponseBlock)
+			for k, partitions := 0, d.compactArrayLength(); k < partitions && d.err == nil; k++ {
+				partition := d.int32()
+				block := &sarama.OffsetFetchResponseBlock{Offset: d.int64(), LeaderEpoch: d.int32()}
+				block.Metadata = d.compactNullableString()
+				block.Err = sarama.KError(d.int16())
+				d.skipTaggedFields()
+				blocks[topic][partition] = block
+			}
+			d.skipTaggedFields()
+		}
+		if err := sarama.KError(d.int16()); err != sarama.ErrNoError {
+			groups[groupID] = groupOffsets{err: err}
+		} else {
+			groups[groupID] = groupOffsets{blocks: blocks}
+		}
+		d.skipTaggedFields()
+	}
+	d.skipTaggedFields()
+	if d.err != nil {
+		return nil, d.err
+	}
+	return groups, nil
+}
+
+// brokerConn is a connection to a broker sending the requests sarama does not
+// support, one at a time.
+type brokerConn struct {
+	conn          net.Conn
+	config        *sarama.Config
+	correlationID int32
+}
+
+// dialBroker connects to the broker at addr with the network, TLS and SASL
+// settings of config.
+func dialBroker(addr string, config *sarama.Config) (*brokerConn, error) {
+	dialer := &net.Dialer{
+		Timeout:   config.Net.DialTimeout,
+		KeepAlive: config.Net.KeepAlive,
+		LocalAddr: config.Net.LocalAddr,
+	}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if config.Net.TLS.Enable {
+		tlsConfig := &tls.Config{}
+		if config.Net.TLS.Config != nil {
+			tlsConfig = config.Net.TLS.Config.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &brokerConn{conn: conn, config: config}
+	if config.Net.SASL.Enable {
+		if err := c.authenticate(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *brokerConn) Close() error {
+	return c.conn.Close()
+}
+
+// roundTrip sends a request and returns the body of its response. Flexible
+// versions have tagged fields in their headers.
+func (c *brokerConn) roundTrip(apiKey int16, version int16, flexible bool, body []byte) ([]byte, error) {
+	c.correlationID++
+	var header kafkaEncoder
+	header.int16(apiKey)
+	header.int16(version)
+	header.int32(c.correlationID)
+	header.string(c.config.ClientID)
+	if flexible {
+		header.emptyTaggedFields()
+	}
+	request := make([]byte, 4, 4+header.Len()+len(body))
+	binary.BigEndian.PutUint32(request, uint32(header.Len()+len(body)))
+	request = append(append(request, header.Bytes()...), body...)
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.config.Net.ReadTimeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(c.conn, response); err != nil {
+		return nil, err
+	}
+
+	d := kafkaDecoder{buf: response}
+	if correlationID := d.int32(); d.err == nil && correlationID != c.correlationID {
+		return nil, fmt.Errorf("correlation ID didn't match, wanted %d, got %d", c.correlationID, correlationID)
+	}
+	if flexible {
+		d.skipTaggedFields()
+	}
+	return d.buf, d.err
+}
+
+// authenticate performs the SASL handshake and authentication of
+// KIP-152, supported by every broker supporting OffsetFetch v8.
+func (c *brokerConn) authenticate() error {
+	sasl := c.config.Net.SASL
+	var handshake kafkaEncoder
+	handshake.string(string(sasl.Mechanism))
+	response, err := c.roundTrip(apiKeySaslHandshake, 1, false, handshake.Bytes())
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{buf: response}
+	if err := sarama.KError(d.int16()); d.err == nil && err != sarama.ErrNoError {
+		return err
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	switch sasl.Mechanism {
+	case sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512:
+		client := sasl.SCRAMClientGeneratorFunc()
+		if err := client.Begin(sasl.User, sasl.Password, sasl.SCRAMAuthzID); err != nil {
+			return fmt.Errorf("failed to start SCRAM exchange with the server: %v", err)
+		}
+		message, err := client.Step("")
+		if err != nil {
+			return fmt.Errorf("failed to advance the SCRAM exchange: %v", err)
+		}
+		for !client.Done() {
+			challenge, err := c.saslAuthenticate([]byte(message))
+			if err != nil {
+				return err
+			}
+			if message, err = client.Step(string(challenge)); err != nil {
+				return fmt.Errorf("failed to advance the SCRAM exchange: %v", err)
+			}
+		}
+		return nil
+	case sarama.SASLTypeOAuth:
+		token, err := sasl.TokenProvider.Token()
+		if err != nil {
+			return err
+		}
+		var extensions string
+		if len(token.Extensions) > 0 {
+			pairs := make([]string, 0, len(token.Extensions))
+			for key, value := range token.Extensions {
+				pairs = append(pairs, key+"="+value)
+			}
+			sort.Strings(pairs)
+			extensions = "\x01" + strings.Join(pairs, "\x01")
+		}
+		_, err = c.saslAuthenticate([]byte("n,,\x01auth=Bearer " + token.Token + extensions + "\x01\x01"))
+		return err
+	default:
+		_, err := c.saslAuthenticate([]byte(sasl.AuthIdentity + "\x00" + sasl.User + "\x00" + sasl.Password))
+		return err
+	}
+}
+
+// saslAuthenticate sends a SaslAuthenticate v1 request and returns the
+// challenge of the broker.
+func (c *brokerConn) saslAuthenticate(message []byte) ([]byte, error) {
+	var request kafkaEncoder
+	request.bytes(message)
+	response, err := c.roundTrip(apiKeySaslAuthenticate, 1, false, request.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	d := kafkaDecoder{buf: response}
+	kerr := sarama.KError(d.int16())
+	errorMessage := d.nullableString()
+	challenge := d.bytes()
+	switch {
+	case d.err != nil:
+		return nil, d.err
+	case kerr != sarama.ErrNoError && errorMessage != nil:
+		return nil, fmt.Errorf("%w: %s", kerr, *errorMessage)
+	case kerr != sarama.ErrNoError:
+		return nil, kerr
+	}
+	return challenge, nil
+}
+
+// kafkaEncoder encodes the primitive types of the Kafka protocol.
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *kafkaEncoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.WriteString(v)
+}
+
+func (e *kafkaEncoder) bytes(v []byte) {
+	e.int32(int32(len(v)))
+	e.Write(v)
+}
+
+func (e *kafkaEncoder) compactString(v string) {
+	e.uvarint(uint64(len(v) + 1))
+	e.WriteString(v)
+}
+
+// compactArrayLength encodes the length of a compact array, -1 for null.
+func (e *kafkaEncoder) compactArrayLength(n int) {
+	e.uvarint(uint64(n + 1))
+}
+
+func (e *kafkaEncoder) emptyTaggedFields() {
+	e.uvarint(0)
+}
+
+// kafkaDecoder decodes the primitive types of the Kafka protocol. The first
+// error is kept and the following reads return zero values.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errTruncatedResponse
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncatedResponse
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *kafkaDecoder) nullableString() *string {
+	n := d.int16()
+	if n < 0 {
+		return nil
+	}
+	s := string(d.next(int(n)))
+	return &s
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *kafkaDecoder) compactString() string {
+	return string(d.next(int(d.uvarint()) - 1))
+}
+
+func (d *kafkaDecoder) compactNullableString() string {
+	n := int(d.uvarint())
+	if n == 0 {
+		return ""
+	}
+	return string(d.next(n - 1))
+}
+
+// compactArrayLength decodes the length of a compact array, 0 for null.
+func (d *kafkaDecoder) compactArrayLength() int {
+	if n := int(d.uvarint()); n > 0 {
+		return n - 1
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) skipTaggedFields() {
+	for i, n := uint64(0), d.uvarint(); i < n && d.err == nil; i++ {
+		d.uvarint() // tag
+		d.next(int(d.uvarint()))
+	}
+}
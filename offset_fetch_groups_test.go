@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// fakeBroker answers the requests sarama does not support with handle,
+// given their API key, version and body.
+func fakeBroker(t *testing.T, handle func(apiKey int16, version int16, body []byte) []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var size [4]byte
+					if _, err := io.ReadFull(conn, size[:]); err != nil {
+						return
+					}
+					request := make([]byte, binary.BigEndian.Uint32(size[:]))
+					if _, err := io.ReadFull(conn, request); err != nil {
+						return
+					}
+					d := kafkaDecoder{buf: request}
+					apiKey, version, correlationID := d.int16(), d.int16(), d.int32()
+					d.nullableString() // client_id
+					flexible := apiKey == apiKeyOffsetFetch && version >= 6
+					if flexible {
+						d.skipTaggedFields()
+					}
+					var response kafkaEncoder
+					response.int32(correlationID)
+					if flexible {
+						response.emptyTaggedFields()
+					}
+					response.Write(handle(apiKey, version, d.buf))
+					var out kafkaEncoder
+					out.bytes(response.Bytes())
+					if _, err := conn.Write(out.Bytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestEncodeOffsetFetchGroupsRequest(t *testing.T) {
+	body := encodeOffsetFetchGroupsRequest([]offsetFetchGroup{
+		{groupID: "g", topics: map[string][]int32{"t": {0}}},
+		{groupID: "h"},
+	})
+	expected := []byte{
+		0x03,      // 2 groups
+		0x02, 'g', // group_id
+		0x02, 0x02, 't', // 1 topic
+		0x02, 0x00, 0x00, 0x00, 0x00, // partition 0
+		0x00, 0x00, // tagged fields of the topic and the group
+		0x02, 'h', // group_id
+		0x00, // all the topics
+		0x00, // tagged fields of the group
+		0x00, // require_stable
+		0x00, // tagged fields
+	}
+	if !bytes.Equal(body, expected) {
+		t.Errorf("expected %x, got %x", expected, body)
+	}
+}
+
+func TestFetchGroupsOffsets(t *testing.T) {
+	var requested []offsetFetchGroup
+	addr := fakeBroker(t, func(apiKey int16, version int16, body []byte) []byte {
+		if apiKey != apiKeyOffsetFetch || version != offsetFetchGroupsVersion {
+			t.Errorf("unexpected request %d v%d", apiKey, version)
+			return nil
+		}
+		d := kafkaDecoder{buf: body}
+		for i, n := 0, d.compactArrayLength(); i < n; i++ {
+			group := offsetFetchGroup{groupID: d.compactString()}
+			if topics := int(d.uvarint()) - 1; topics >= 0 {
+				group.topics = make(map[string][]int32)
+				for j := 0; j < topics; j++ {
+					topic := d.compactString()
+					for k, partitions := 0, d.compactArrayLength(); k < partitions; k++ {
+						group.topics[topic] = append(group.topics[topic], d.int32())
+					}
+					d.skipTaggedFields()
+				}
+			}
+			d.skipTaggedFields()
+			requested = append(requested, group)
+		}
+
+		var e kafkaEncoder
+		e.int32(0) // throttle_time_ms
+		e.compactArrayLength(3)
+		for _, group := range []struct {
+			id     string
+			topic  string
+			offset int64
+			err    sarama.KError
+		}{
+			{"orders", "orders", 5, sarama.ErrNoError},
+			{"billing", "payments", 7, sarama.ErrNoError},
+			{"moved", "", 0, sarama.ErrNotCoordinatorForConsumer},
+		} {
+			e.compactString(group.id)
+			if group.topic == "" {
+				e.compactArrayLength(0)
+			} else {
+				e.compactArrayLength(1)
+				e.compactString(group.topic)
+				e.compactArrayLength(1)
+				e.int32(0)
+				e.int64(group.offset)
+				e.int32(-1) // committed_leader_epoch
+				e.compactArrayLength(-1)
+				e.int16(0)
+				e.emptyTaggedFields()
+				e.emptyTaggedFields()
+			}
+			e.int16(int16(group.err))
+			e.emptyTaggedFields()
+		}
+		e.emptyTaggedFields()
+		return e.Bytes()
+	})
+
+	seed := sarama.NewMockBroker(t, 1)
+	defer seed.Close()
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).SetBroker(seed.Addr(), seed.BrokerID()),
+	})
+	client, err := sarama.NewClient([]string{seed.Addr()}, sarama.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, showAll := range []bool{false, true} {
+		requested = nil
+		e := &Exporter{client: client, offsetShowAll: showAll}
+		offsets, err := e.fetchGroupsOffsets(sarama.NewBroker(addr), []describedGroup{
+			{group: &sarama.GroupDescription{GroupId: "orders"}, partitions: map[string][]int32{"orders": {0}}},
+			// The offsets of the topics that are not collected are dropped.
+			{group: &sarama.GroupDescription{GroupId: "billing"}, partitions: map[string][]int32{"orders": {0}}},
+			{group: &sarama.GroupDescription{GroupId: "moved"}, partitions: map[string][]int32{"orders": {0}}},
+			{group: &sarama.GroupDescription{GroupId: "idle"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var groupIDs []string
+		for _, group := range requested {
+			groupIDs = append(groupIDs, group.groupID)
+			if (group.topics == nil) != showAll {
+				t.Errorf("show-all %t: unexpected topics %v for group %s", showAll, group.topics, group.groupID)
+			}
+		}
+		sort.Strings(groupIDs)
+		if expected := []string{"billing", "moved", "orders"}; !reflect.DeepEqual(groupIDs, expected) {
+			t.Errorf("show-all %t: expected a single request for the groups %v, got %v", showAll, expected, groupIDs)
+		}
+
+		if block := offsets["orders"].blocks["orders"][0]; block == nil || block.Offset != 5 {
+			t.Errorf("show-all %t: expected the offset 5, got %+v", showAll, offsets["orders"])
+		}
+		if billing := offsets["billing"]; billing.err != nil || len(billing.blocks) != 0 {
+			t.Errorf("show-all %t: expected no offset of topics that are not collected, got %+v", showAll, billing)
+		}
+		if moved := offsets["moved"]; moved.err != sarama.ErrNotCoordinatorForConsumer {
+			t.Errorf("show-all %t: expected the group to have moved, got %+v", showAll, moved)
+		}
+		if idle, ok := offsets["idle"]; !ok || idle.err != nil || idle.blocks != nil {
+			t.Errorf("show-all %t: expected no offset fetched for the group without partitions, got %+v", showAll, idle)
+		}
+	}
+}
+
+func TestDialBrokerSASLPlain(t *testing.T) {
+	var mechanism, auth string
+	addr := fakeBroker(t, func(apiKey int16, version int16, body []byte) []byte {
+		var e kafkaEncoder
+		d := kafkaDecoder{buf: body}
+		switch apiKey {
+		case apiKeySaslHandshake:
+			mechanism = *d.nullableString()
+			e.int16(0)
+			e.int32(1)
+			e.string(sarama.SASLTypePlaintext)
+		case apiKeySaslAuthenticate:
+			auth = string(d.bytes())
+			if auth == "\x00exporter\x00secret" {
+				e.int16(0)
+				e.int16(-1)
+			} else {
+				e.int16(int16(sarama.ErrSASLAuthenticationFailed))
+				e.string("invalid credentials")
+			}
+			e.int32(0)
+			e.int64(0)
+		}
+		return e.Bytes()
+	})
+
+	config := sarama.NewConfig()
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	config.Net.SASL.User = "exporter"
+	config.Net.SASL.Password = "secret"
+	if !offsetFetchGroupsSupported(config) {
+		t.Fatal("expected SASL/PLAIN to be supported")
+	}
+	conn, err := dialBroker(addr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if mechanism != sarama.SASLTypePlaintext || auth != "\x00exporter\x00secret" {
+		t.Errorf("unexpected handshake %q and authentication %q", mechanism, auth)
+	}
+
+	config.Net.SASL.Password = "wrong"
+	if _, err := dialBroker(addr, config); !isSASLError(err) {
+		t.Errorf("expected an authentication failure, got %v", err)
+	}
+
+	config.Net.SASL.Mechanism = sarama.SASLTypeGSSAPI
+	if offsetFetchGroupsSupported(config) {
+		t.Error("expected GSSAPI not to be supported")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// offsetSample is the newest offset of a partition at a point in time.
+type offsetSample struct {
+	timestamp int64 // unix nanoseconds
+	offset    int64
+}
+
+// offsetHistory keeps a bounded list of newest offset samples for every
+// partition. Looking up where a committed offset falls in that list gives an
+// estimate of when the next message to consume was produced, and so of how
+// many seconds a consumer group is behind.
+type offsetHistory struct {
+	mu         sync.Mutex
+	maxSamples int
+	partitions map[string]map[int32][]offsetSample
+}
+
+func newOffsetHistory(maxSamples int) *offsetHistory {
+	return &offsetHistory{
+		maxSamples: maxSamples,
+		partitions: make(map[string]map[int32][]offsetSample),
+	}
+}
+
+// add records the newest offset of a partition seen at time ts.
+func (h *offsetHistory) add(topic string, partition int32, offset int64, ts time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	partitions, ok := h.partitions[topic]
+	if !ok {
+		partitions = make(map[int32][]offsetSample)
+		h.partitions[topic] = partitions
+	}
+	samples := partitions[partition]
+	sample := offsetSample{timestamp: ts.UnixNano(), offset: offset}
+
+	if n := len(samples); n > 0 {
+		last := samples[n-1]
+		switch {
+		case offset < last.offset:
+			// The partition has been recreated, the history is meaningless.
+			samples = samples[:0]
+		case offset == last.offset && n > 1 && samples[n-2].offset == offset:
+			// Nothing was produced: only keep the first and the last time this
+			// offset was seen, which is all the interpolation needs.
+			samples[n-1] = sample
+			partitions[partition] = samples
+			return
+		}
+	}
+
+	if len(samples) >= h.maxSamples {
+		copy(samples, samples[1:])
+		samples = samples[:len(samples)-1]
+	}
+	partitions[partition] = append(samples, sample)
+}
+
+// lagSeconds returns how long ago the message at the committed offset was
+// produced. When the committed offset is older than the history, the produce
+// time is extrapolated from the average produce rate of the history.
+func (h *offsetHistory) lagSeconds(topic string, partition int32, committed int64, now time.Time) (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := h.partitions[topic][partition]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	newest := samples[len(samples)-1]
+	if committed >= newest.offset {
+		return 0, true
+	}
+
+	var producedAt float64
+	oldest := samples[0]
+	if committed < oldest.offset {
+		if len(samples) < 2 || newest.offset == oldest.offset {
+			producedAt = float64(oldest.timestamp)
+		} else {
+			rate := float64(newest.offset-oldest.offset) / float64(newest.timestamp-oldest.timestamp)
+			producedAt = float64(oldest.timestamp) - float64(oldest.offset-committed)/rate
+		}
+	} else {
+		// Samples are sorted by offset, find the pair enclosing the committed offset.
+		for i := len(samples) - 1; i > 0; i-- {
+			before, after := samples[i-1], samples[i]
+			if committed >= before.offset && committed < after.offset {
+				ratio := float64(committed-before.offset) / float64(after.offset-before.offset)
+				producedAt = float64(before.timestamp) + ratio*float64(after.timestamp-before.timestamp)
+				break
+			}
+		}
+	}
+
+	lag := (float64(now.UnixNano()) - producedAt) / float64(time.Second)
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, true
+}
+
+// prune forgets the partitions that are not in topics anymore, e.g. because
+// the topic was deleted or does not match the topic filter anymore. A topic
+// mapped to nil partitions is kept as is.
+func (h *offsetHistory) prune(topics map[string][]int32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for topic, partitions := range h.partitions {
+		current, ok := topics[topic]
+		if !ok {
+			delete(h.partitions, topic)
+			continue
+		}
+		if current == nil || len(partitions) <= len(current) {
+			continue
+		}
+		keep := make(map[int32]bool, len(current))
+		for _, partition := range current {
+			keep[partition] = true
+		}
+		for partition := range partitions {
+			if !keep[partition] {
+				delete(partitions, partition)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOffsetHistoryLagSeconds(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	h := newOffsetHistory(10)
+	// 10 messages per second.
+	for i := 0; i <= 5; i++ {
+		h.add("orders", 0, int64(100+i*100), start.Add(time.Duration(i*10)*time.Second))
+	}
+	now := start.Add(50 * time.Second)
+
+	for _, tc := range []struct {
+		committed int64
+		expected  float64
+	}{
+		{committed: 600, expected: 0},
+		{committed: 700, expected: 0},
+		{committed: 500, expected: 10},
+		{committed: 450, expected: 15},
+		{committed: 100, expected: 50},
+		// Older than the history, extrapolated at 10 messages per second.
+		{committed: 0, expected: 60},
+	} {
+		lag, ok := h.lagSeconds("orders", 0, tc.committed, now)
+		if !ok {
+			t.Fatalf("committed %d: no lag estimated", tc.committed)
+		}
+		if math.Abs(lag-tc.expected) > 1e-6 {
+			t.Errorf("committed %d: expected %v seconds of lag, got %v", tc.committed, tc.expected, lag)
+		}
+	}
+
+	if _, ok := h.lagSeconds("orders", 1, 0, now); ok {
+		t.Error("expected no lag for a partition without history")
+	}
+}
+
+func TestOffsetHistoryIdlePartition(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	h := newOffsetHistory(3)
+	h.add("orders", 0, 100, start)
+	for i := 1; i <= 10; i++ {
+		h.add("orders", 0, 200, start.Add(time.Duration(i)*time.Second))
+	}
+	h.add("orders", 0, 300, start.Add(20*time.Second))
+
+	// Offsets 200 to 299 were produced between the 10th and 20th second.
+	lag, _ := h.lagSeconds("orders", 0, 250, start.Add(20*time.Second))
+	if math.Abs(lag-5) > 1e-6 {
+		t.Errorf("expected 5 seconds of lag, got %v", lag)
+	}
+	if samples := h.partitions["orders"][0]; len(samples) != 3 {
+		t.Errorf("expected the history to be bounded to 3 samples, got %d", len(samples))
+	}
+}
+
+func TestOffsetHistoryResetAndPrune(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	h := newOffsetHistory(10)
+	h.add("orders", 0, 100, now)
+	h.add("orders", 0, 10, now.Add(time.Second))
+	if samples := h.partitions["orders"][0]; len(samples) != 1 || samples[0].offset != 10 {
+		t.Errorf("expected the history to be reset, got %v", samples)
+	}
+
+	h.add("orders", 1, 10, now)
+	h.add("deleted", 0, 10, now)
+	h.add("unknown", 0, 10, now)
+	h.prune(map[string][]int32{"orders": {0}, "unknown": nil})
+	if _, ok := h.partitions["deleted"]; ok {
+		t.Error("expected deleted topic to be pruned")
+	}
+	if _, ok := h.partitions["orders"][1]; ok {
+		t.Error("expected deleted partition to be pruned")
+	}
+	if _, ok := h.partitions["unknown"][0]; !ok {
+		t.Error("expected topic with unknown partitions to be kept")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// fetchOffsets returns the newest or oldest offsets, depending on offsetTime,
+// of the partitions led by each broker. A single ListOffsets request is sent
+// to every leader and the leaders are queried concurrently. Partitions whose
+// offset cannot be fetched are missing from the result.
+func (e *Exporter) fetchOffsets(leaders map[*sarama.Broker]map[string][]int32, offsetTime int64) map[string]map[int32]int64 {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		offsets = make(map[string]map[int32]int64)
+	)
+
+	for broker, topics := range leaders {
+		wg.Add(1)
+		go func(broker *sarama.Broker, topics map[string][]int32) {
+			defer wg.Done()
+
+			request := &sarama.OffsetRequest{}
+			if e.client.Config().Version.IsAtLeast(sarama.V0_10_1_0) {
+				request.Version = 1
+			}
+			for topic, partitions := range topics {
+				for _, partition := range partitions {
+					request.AddBlock(topic, partition, offsetTime, 1)
+				}
+			}
+
+			response, err := broker.GetAvailableOffsets(request)
+			e.stats.request("ListOffsets", broker, err)
+			if err != nil {
+				e.scrapeErrorf(phaseOffsets, "Cannot get offsets from broker %d: %v", broker.ID(), err)
+				// The client reopens the connection the next time it looks the leader up.
+				_ = broker.Close()
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for topic, partitions := range topics {
+				for _, partition := range partitions {
+					block := response.GetBlock(topic, partition)
+					switch {
+					case block == nil:
+						e.scrapeErrorf(phaseOffsets, "Cannot get offset of topic %s partition %d: %v", topic, partition, sarama.ErrIncompleteResponse)
+					case block.Err != sarama.ErrNoError:
+						e.scrapeErrorf(phaseOffsets, "Cannot get offset of topic %s partition %d: %v", topic, partition, block.Err)
+					case len(block.Offsets) != 1:
+						e.scrapeErrorf(phaseOffsets, "Cannot get offset of topic %s partition %d: %v", topic, partition, sarama.ErrOffsetOutOfRange)
+					default:
+						if _, ok := offsets[topic]; !ok {
+							offsets[topic] = make(map[int32]int64, len(partitions))
+						}
+						offsets[topic][partition] = block.Offsets[0]
+					}
+				}
+			}
+		}(broker, topics)
+	}
+	wg.Wait()
+
+	return offsets
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFetchOffsetsBatchesPerLeader(t *testing.T) {
+	seed := sarama.NewMockBroker(t, 1)
+	defer seed.Close()
+	leader := sarama.NewMockBroker(t, 2)
+	defer leader.Close()
+
+	seed.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(leader.Addr(), leader.BrokerID()).
+			SetLeader("orders", 0, leader.BrokerID()).
+			SetLeader("orders", 1, leader.BrokerID()).
+			SetLeader("payments", 0, leader.BrokerID()),
+	})
+	leader.SetHandlerByMap(map[string]sarama.MockResponse{
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetVersion(1).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 1, sarama.OffsetNewest, 20).
+			SetOffset("payments", 0, sarama.OffsetNewest, 30),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{seed.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	broker, err := client.Leader("orders", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{client: client}
+	offsets := e.fetchOffsets(map[*sarama.Broker]map[string][]int32{
+		broker: {"orders": {0, 1}, "payments": {0}},
+	}, sarama.OffsetNewest)
+
+	if offsets["orders"][0] != 10 || offsets["orders"][1] != 20 || offsets["payments"][0] != 30 {
+		t.Errorf("unexpected offsets %v", offsets)
+	}
+	requests := 0
+	for _, r := range leader.History() {
+		if _, ok := r.Request.(*sarama.OffsetRequest); ok {
+			requests++
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected a single ListOffsets request, got %d", requests)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type probeKey struct {
+	module string
+	target string
+}
+
+// probeTarget is a cluster scraped through the probe endpoint.
+type probeTarget struct {
+	collector *clusterCollector
+	registry  *prometheus.Registry
+	lastProbe time.Time
+
+	// Held for reading while the target is probed, and for writing when it
+	// is closed, so that in-flight probes complete first.
+	mu sync.RWMutex
+}
+
+func (t *probeTarget) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.collector.Close(); err != nil {
+		glog.Errorf("Cannot close Kafka client of probe target %s: %v", t.collector.name, err)
+	}
+}
+
+// probeModule holds the settings the targets of a module are scraped with.
+type probeModule struct {
+	cfg clusterConfig
+	// The bootstrap servers allowed, any when nil.
+	targets *regexp.Regexp
+}
+
+// prober serves the metrics of the clusters whose bootstrap servers are given
+// with the target URL parameter, scraped with the settings of the module URL
+// parameter. Only the modules of the config file can be probed, so that the
+// exporter does not connect to arbitrary servers without being configured
+// to. The Kafka clients of the targets are reused by the following probes
+// and closed once a target has not been probed for the probe TTL, the
+// targets that cannot be connected to are not kept.
+type prober struct {
+	modules map[string]probeModule
+	flags   kafkaOpts
+	quit    chan struct{}
+
+	mu      sync.Mutex
+	targets map[probeKey]*probeTarget
+}
+
+func newProber(cfg *fileConfig, flags kafkaOpts) *prober {
+	modules := make(map[string]probeModule, len(cfg.Modules))
+	for name, module := range cfg.Modules {
+		// Checked when loading the config file.
+		targets, _ := module.probeTargets()
+		modules[name] = probeModule{cfg: module, targets: targets}
+	}
+	p := &prober{
+		modules: modules,
+		flags:   flags,
+		quit:    make(chan struct{}),
+		targets: make(map[probeKey]*probeTarget),
+	}
+	if flags.probeTTL > 0 {
+		go p.evictLoop(flags.probeTTL)
+	}
+	return p
+}
+
+// ServeHTTP probes the target. It fails when the target cannot be connected
+// to, so that the scrape is reported as down.
+func (p *prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	name := r.URL.Query().Get("module")
+	if name == "" {
+		http.Error(w, "module parameter is missing", http.StatusBadRequest)
+		return
+	}
+	module, ok := p.modules[name]
+	if !ok {
+		http.Error(w, "unknown module "+name, http.StatusBadRequest)
+		return
+	}
+	if module.targets != nil {
+		for _, broker := range strings.Split(target, ",") {
+			if !module.targets.MatchString(broker) {
+				http.Error(w, fmt.Sprintf("target %s is not allowed by module %s", broker, name), http.StatusForbidden)
+				return
+			}
+		}
+	}
+
+	key := probeKey{module: name, target: target}
+	t, err := p.target(key, module.cfg)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot probe %s with module %s: %v", target, name, err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := t.collector.getExporter(); err != nil {
+		t.mu.RUnlock()
+		p.forget(key, t)
+		http.Error(w, fmt.Sprintf("cannot connect to %s: %v", target, err), http.StatusServiceUnavailable)
+		return
+	}
+	defer t.mu.RUnlock()
+	promhttp.HandlerFor(t.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// target returns the target of key, creating it if needed. It is returned
+// locked for reading.
+func (p *prober) target(key probeKey, cfg clusterConfig) (*probeTarget, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t, ok := p.targets[key]
+	if !ok {
+		cfg = cfg.clone()
+		cfg.Name = key.target
+		cfg.Brokers = strings.Split(key.target, ",")
+		t = &probeTarget{
+			collector: newClusterCollector(cfg, p.flags),
+			registry:  prometheus.NewRegistry(),
+		}
+		if err := prometheus.WrapRegistererWith(cfg.Labels, t.registry).Register(t.collector); err != nil {
+			return nil, err
+		}
+		p.targets[key] = t
+		glog.Infof("Probing new target %s with module %q", key.target, key.module)
+	}
+	t.lastProbe = time.Now()
+	// Taken before releasing p.mu so that the target cannot be evicted
+	// before this probe starts.
+	t.mu.RLock()
+	return t, nil
+}
+
+// forget closes a target that cannot be connected to, so that the targets
+// that never connect do not pile up. The next probe of the target creates it
+// again.
+func (p *prober) forget(key probeKey, t *probeTarget) {
+	p.mu.Lock()
+	if p.targets[key] == t {
+		delete(p.targets, key)
+	}
+	p.mu.Unlock()
+	t.close()
+}
+
+// reconnect recreates the Kafka clients of the targets probed with module,
+// which keep their state. The targets whose client cannot be recreated are
+// closed, their next probe reporting the failure.
+func (p *prober) reconnect(module string) {
+	p.mu.Lock()
+	targets := make(map[probeKey]*probeTarget)
+	for key, t := range p.targets {
+		if key.module == module {
+			targets[key] = t
+		}
+	}
+	p.mu.Unlock()
+
+	for key, t := range targets {
+		if err := t.collector.reconnect(&t.mu); err != nil {
+			glog.Errorf("Cannot recreate Kafka client of probe target %s, closing it: %v", key.target, err)
+			p.forget(key, t)
+		}
+	}
+}
+
+func (p *prober) evictLoop(ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.evict(now.Add(-ttl))
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// evict closes the targets that were not probed since before.
+func (p *prober) evict(before time.Time) {
+	p.mu.Lock()
+	var idle []*probeTarget
+	for key, t := range p.targets {
+		if t.lastProbe.Before(before) {
+			delete(p.targets, key)
+			idle = append(idle, t)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, t := range idle {
+		glog.Infof("Closing idle probe target %s", t.collector.name)
+		t.close()
+	}
+}
+
+// Close stops the evictions and closes all the targets.
+func (p *prober) Close() error {
+	close(p.quit)
+	p.mu.Lock()
+	targets := p.targets
+	p.targets = make(map[probeKey]*probeTarget)
+	p.mu.Unlock()
+
+	for _, t := range targets {
+		t.close()
+	}
+	return nil
+}
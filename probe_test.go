@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testProbeModules returns a module allowing any target, and one allowing
+// the brokers of a single domain.
+func testProbeModules() *fileConfig {
+	restricted := testDefaults()
+	restricted.ProbeTargets = `kafka-\d+\.example\.com:9092`
+	return &fileConfig{
+		Global:  testDefaults(),
+		Modules: map[string]clusterConfig{"any": testDefaults(), "restricted": restricted},
+	}
+}
+
+func TestProberBadRequests(t *testing.T) {
+	p := newProber(testProbeModules(), kafkaOpts{})
+	defer p.Close()
+
+	for url, expected := range map[string]int{
+		"/probe":                   http.StatusBadRequest,
+		"/probe?target=kafka:9092": http.StatusBadRequest,
+		"/probe?target=kafka:9092&module=missing":                                      http.StatusBadRequest,
+		"/probe?target=kafka-1.example.com:9092,kafka.internal:9092&module=restricted": http.StatusForbidden,
+		"/probe?target=kafka-1.example.com:9092.internal:9092&module=restricted":       http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != expected {
+			t.Errorf("%s: expected status %d, got %d", url, expected, w.Code)
+		}
+	}
+}
+
+func TestProberForgetsUnreachableTargets(t *testing.T) {
+	p := newProber(testProbeModules(), kafkaOpts{})
+	defer p.Close()
+
+	cfg := testDefaults()
+	cfg.Brokers = []string{"localhost:0"}
+	p.targets[probeKey{module: "any", target: "localhost:0"}] = &probeTarget{
+		collector: newClusterCollector(cfg, kafkaOpts{}),
+		registry:  prometheus.NewRegistry(),
+	}
+
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe?target=localhost:0&module=any", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if len(p.targets) != 0 {
+		t.Errorf("expected the unreachable target to be forgotten, got %v", p.targets)
+	}
+}
+
+func TestProberEvict(t *testing.T) {
+	p := newProber(testProbeModules(), kafkaOpts{})
+	defer p.Close()
+
+	now := time.Now()
+	idle := probeKey{target: "kafka-1:9092"}
+	active := probeKey{module: "prod", target: "kafka-2:9092"}
+	p.targets[idle] = &probeTarget{collector: newClusterCollector(clusterConfig{Name: idle.target}, kafkaOpts{}), lastProbe: now.Add(-10 * time.Minute)}
+	p.targets[active] = &probeTarget{collector: newClusterCollector(clusterConfig{Name: active.target}, kafkaOpts{}), lastProbe: now}
+
+	p.evict(now.Add(-5 * time.Minute))
+	if _, ok := p.targets[idle]; ok {
+		t.Error("expected idle target to be evicted")
+	}
+	if _, ok := p.targets[active]; !ok {
+		t.Error("expected active target to be kept")
+	}
+}
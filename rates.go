@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateKey identifies a sum of offsets: the newest offsets of a topic when
+// group is empty, the committed offsets of a consumer group on a topic
+// otherwise.
+type rateKey struct {
+	group string
+	topic string
+}
+
+type rateSample struct {
+	timestamp time.Time
+	sum       int64
+}
+
+// rateTracker computes per second rates of offset sums between two
+// consecutive scrapes. Sums that were not observed during the last scrape
+// are forgotten when the scrape ends.
+type rateTracker struct {
+	mu       sync.Mutex
+	previous map[rateKey]rateSample
+	current  map[rateKey]rateSample
+}
+
+func newRateTracker() *rateTracker {
+	return &rateTracker{
+		previous: make(map[rateKey]rateSample),
+		current:  make(map[rateKey]rateSample),
+	}
+}
+
+// observe records the value of a sum at time ts and returns its rate since
+// the previous scrape. No rate is returned for the first observation or when
+// the sum went backwards, e.g. because the topic was recreated.
+func (r *rateTracker) observe(key rateKey, sum int64, ts time.Time) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current[key] = rateSample{timestamp: ts, sum: sum}
+	previous, ok := r.previous[key]
+	if !ok || sum < previous.sum || !ts.After(previous.timestamp) {
+		return 0, false
+	}
+	return float64(sum-previous.sum) / ts.Sub(previous.timestamp).Seconds(), true
+}
+
+// rotate ends a scrape: the sums observed during it become the reference of
+// the next one.
+func (r *rateTracker) rotate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.previous = r.current
+	r.current = make(map[rateKey]rateSample, len(r.previous))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateTracker(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	orders := rateKey{topic: "orders"}
+
+	for _, tc := range []struct {
+		name     string
+		previous int64
+		sum      int64
+		elapsed  time.Duration
+		expected float64
+		ok       bool
+	}{
+		{name: "first observation", previous: -1, sum: 100, elapsed: 10 * time.Second},
+		{name: "normal rate", previous: 100, sum: 600, elapsed: 10 * time.Second, expected: 50, ok: true},
+		{name: "no new messages", previous: 600, sum: 600, elapsed: 10 * time.Second, expected: 0, ok: true},
+		{name: "offsets going backwards", previous: 600, sum: 10, elapsed: 10 * time.Second},
+		{name: "same timestamp", previous: 100, sum: 200, elapsed: 0},
+	} {
+		r := newRateTracker()
+		if tc.previous >= 0 {
+			r.observe(orders, tc.previous, start)
+			r.rotate()
+		}
+		rate, ok := r.observe(orders, tc.sum, start.Add(tc.elapsed))
+		if ok != tc.ok || math.Abs(rate-tc.expected) > 1e-9 {
+			t.Errorf("%s: expected rate %v (%t), got %v (%t)", tc.name, tc.expected, tc.ok, rate, ok)
+		}
+	}
+}
+
+func TestRateTrackerForgetsUnobservedSums(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	orders := rateKey{topic: "orders"}
+	group := rateKey{group: "billing", topic: "orders"}
+
+	r := newRateTracker()
+	r.observe(orders, 100, start)
+	r.observe(group, 100, start)
+	r.rotate()
+	// The group is not observed during the second scrape.
+	r.observe(orders, 200, start.Add(10*time.Second))
+	r.rotate()
+
+	if rate, ok := r.observe(orders, 300, start.Add(20*time.Second)); !ok || rate != 10 {
+		t.Errorf("expected 10 messages per second, got %v (%t)", rate, ok)
+	}
+	if _, ok := r.observe(group, 300, start.Add(20*time.Second)); ok {
+		t.Error("expected no rate for a sum missing from the previous scrape")
+	}
+}
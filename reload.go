@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful",
+	})
+	configLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful configuration reload",
+	})
+)
+
+// reloader builds the collectors from the config file and the command line
+// flags, and rebuilds them when the configuration is reloaded. The new
+// collectors are only swapped in once connected to their clusters, or after
+// reloadConnectTimeout, so that scrapes keep being served by the old ones in
+// the meantime and a configuration that cannot be loaded leaves the old one
+// running. The new collectors take over the offset history, rates, counters
+// and statuses of the old ones of the same clusters.
+type reloader struct {
+	path     string
+	defaults clusterConfig
+	flags    kafkaOpts
+
+	// Serializes reloads.
+	reloadMu sync.Mutex
+
+	mu  sync.RWMutex
+	set *collectorSet
+}
+
+// newReloader loads the initial configuration. Without a config file the
+// settings come from the command line flags alone.
+func newReloader(path string, defaults clusterConfig, flags kafkaOpts) (*reloader, error) {
+	r := &reloader{path: path, defaults: defaults, flags: flags}
+	set, err := r.load(nil)
+	if err != nil {
+		return nil, err
+	}
+	r.set = set
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	return r, nil
+}
+
+// load builds the collectors, which take over the state of previous, if
+// any.
+func (r *reloader) load(previous *collectorSet) (*collectorSet, error) {
+	cfg := &fileConfig{Global: r.defaults}
+	if r.path != "" {
+		var err error
+		if cfg, err = loadConfigFile(r.path, r.defaults); err != nil {
+			return nil, err
+		}
+	}
+	return newCollectorSet(cfg, r.flags, previous)
+}
+
+// reload rebuilds the collectors and swaps them with the current ones.
+func (r *reloader) reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	start := time.Now()
+	r.mu.RLock()
+	old := r.set
+	r.mu.RUnlock()
+	set, err := r.load(old)
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		glog.Errorf("Cannot reload configuration, keeping the current one: %v", err)
+		return err
+	}
+	// The old collectors hand the clusters over as they connect, and keep
+	// exporting them until then.
+	set.waitConnected(reloadConnectTimeout)
+
+	r.mu.Lock()
+	old.handOver(set)
+	r.set = set
+	r.mu.Unlock()
+	old.Close()
+
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccessTimestamp.SetToCurrentTime()
+	glog.Infof("Reloaded configuration in %v", time.Since(start))
+	return nil
+}
+
+// acquire returns the current collectors, locked for reading.
+func (r *reloader) acquire() *collectorSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := r.set
+	// Taken before releasing r.mu so that a reload cannot close the set
+	// before this scrape starts.
+	set.mu.RLock()
+	return set
+}
+
+// ServeHTTP serves the metrics of the current collectors.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	set := r.acquire()
+	defer set.mu.RUnlock()
+	set.ServeHTTP(w, req)
+}
+
+// probeHandler serves the metrics of a probe target with the modules of the
+// current configuration.
+func (r *reloader) probeHandler(w http.ResponseWriter, req *http.Request) {
+	set := r.acquire()
+	defer set.mu.RUnlock()
+	set.probes.ServeHTTP(w, req)
+}
+
+// healthzHandler serves the liveness of the exporter, which fails when a
+// scrape is stuck.
+func (r *reloader) healthzHandler(w http.ResponseWriter, req *http.Request) {
+	set := r.acquire()
+	defer set.mu.RUnlock()
+	newHealthReport(set.livenessChecks(time.Now())).ServeHTTP(w, req)
+}
+
+// readyHandler serves the readiness of the exporter, which fails when a
+// cluster cannot be scraped.
+func (r *reloader) readyHandler(w http.ResponseWriter, req *http.Request) {
+	set := r.acquire()
+	defer set.mu.RUnlock()
+	newHealthReport(set.readinessChecks(time.Now())).ServeHTTP(w, req)
+}
+
+// reloadHandler reloads the configuration on POST requests.
+func (r *reloader) reloadHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "This endpoint requires a POST request.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		http.Error(w, "failed to reload config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// watchSignals reloads the configuration on SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			glog.Infoln("Received SIGHUP, reloading configuration")
+			_ = r.reload()
+		}
+	}()
+}
+
+// Close releases the current collectors.
+func (r *reloader) Close() {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.set.Close()
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// readSecretFile returns the content of a file holding a secret, without
+// the trailing newline most tools add.
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret file: %v", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// resolveSecrets replaces the secrets given with a file by the content of
+// the file, which takes precedence over the secret given directly.
+func (opts *kafkaOpts) resolveSecrets() error {
+	for _, secret := range []struct {
+		path  string
+		value *string
+	}{
+		{opts.saslPasswordFile, &opts.saslPassword},
+		{opts.oauthClientSecretFile, &opts.oauthClientSecret},
+		{opts.tlsKeyPasswordFile, &opts.tlsKeyPassword},
+	} {
+		if secret.path == "" {
+			continue
+		}
+		value, err := readSecretFile(secret.path)
+		if err != nil {
+			return err
+		}
+		*secret.value = value
+	}
+	return nil
+}
+
+// loadX509KeyPair loads a certificate and its key, decrypting the key with
+// password when it is an encrypted PEM block. Only the legacy PEM encryption
+// is supported, not encrypted PKCS#8 keys.
+func loadX509KeyPair(certFile string, keyFile string, password string) (tls.Certificate, error) {
+	if password == "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("no PEM block found in key file %s", keyFile)
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("cannot decrypt key file %s: %v", keyFile, err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// secretFiles returns the files holding the credentials of the cluster,
+// whose changes require new Kafka clients. The OAUTHBEARER token file is
+// read again by the client itself.
+func (c clusterConfig) secretFiles() []string {
+	var paths []string
+	if c.SASL.Enabled {
+		paths = append(paths, c.SASL.PasswordFile, c.SASL.KeyTabPath, c.SASL.OAuthBearer.ClientSecretFile)
+	}
+	if c.TLS.Enabled {
+		paths = append(paths, c.TLS.CAFile, c.TLS.CertFile, c.TLS.KeyFile, c.TLS.KeyPasswordFile)
+	}
+	return paths
+}
+
+// secretWatch holds the hashes of the secret files of each cluster and
+// module of a configuration, so that only the Kafka clients of those whose
+// files changed are recreated. Without clusters, the top level settings have
+// hashes of their own, otherwise their files are part of the clusters
+// inheriting them.
+type secretWatch struct {
+	global   secretHashes
+	clusters map[string]secretHashes
+	modules  map[string]secretHashes
+}
+
+func newSecretWatch(cfg *fileConfig) secretWatch {
+	w := secretWatch{
+		clusters: make(map[string]secretHashes, len(cfg.Clusters)),
+		modules:  make(map[string]secretHashes, len(cfg.Modules)),
+	}
+	if len(cfg.Clusters) == 0 {
+		w.global = hashSecretFiles(cfg.Global.secretFiles())
+	}
+	for _, cluster := range cfg.Clusters {
+		w.clusters[cluster.Name] = hashSecretFiles(cluster.secretFiles())
+	}
+	for name, module := range cfg.Modules {
+		w.modules[name] = hashSecretFiles(module.secretFiles())
+	}
+	return w
+}
+
+// secretHashes holds the hashes of the content of secret files, to find out
+// when they change. Kubernetes updates mounted secrets by swapping a symbolic
+// link, so the content is compared rather than the modification time.
+type secretHashes map[string][sha256.Size]byte
+
+func hashSecretFiles(paths []string) secretHashes {
+	hashes := make(secretHashes, len(paths))
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		// A file that cannot be read is hashed as empty, so that it is
+		// reported as changed once it can.
+		content, _ := ioutil.ReadFile(path)
+		hashes[path] = sha256.Sum256(content)
+	}
+	return hashes
+}
+
+// rehash returns the hashes of the current content of the same files.
+func (h secretHashes) rehash() secretHashes {
+	paths := make([]string, 0, len(h))
+	for path := range h {
+		paths = append(paths, path)
+	}
+	return hashSecretFiles(paths)
+}
+
+// changed returns the first file whose content is no longer the hashed one.
+func (h secretHashes) changed() (string, bool) {
+	for path, hash := range h {
+		content, _ := ioutil.ReadFile(path)
+		if sha256.Sum256(content) != hash {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// refreshSecrets calls reconnect to recreate the Kafka clients of owner when
+// one of its secret files changed, and returns the hashes to compare the
+// files with next time. They are left unchanged when reconnect fails, so
+// that it is retried at the next check.
+func refreshSecrets(owner string, hashes secretHashes, reconnect func() error) secretHashes {
+	path, changed := hashes.changed()
+	if !changed {
+		return hashes
+	}
+	glog.Infof("Secret file %s of %s changed, recreating its Kafka clients", path, owner)
+	// Hashed before the clients read the files, so that a change in between
+	// is not missed.
+	updated := hashes.rehash()
+	if err := reconnect(); err != nil {
+		glog.Errorf("Cannot recreate the Kafka clients of %s, keeping the current ones: %v", owner, err)
+		return hashes
+	}
+	return updated
+}
+
+// watchSecrets recreates the Kafka clients of the clusters and modules of the
+// current configuration whose secret files changed.
+func (r *reloader) watchSecrets(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			r.checkSecrets()
+		}
+	}()
+}
+
+// checkSecrets recreates the Kafka clients whose secret files changed. The
+// config file is not loaded again, its edits wait for a reload.
+func (r *reloader) checkSecrets() {
+	// Serialized with the reloads, which close the collectors.
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	r.mu.RLock()
+	set := r.set
+	r.mu.RUnlock()
+	set.refreshSecrets()
+}
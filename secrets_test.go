@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kafka_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	writeFile(t, path, []byte("from-file\n"))
+
+	opts := kafkaOpts{saslPassword: "from-flag", saslPasswordFile: path, oauthClientSecret: "secret"}
+	if err := opts.resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if opts.saslPassword != "from-file" || opts.oauthClientSecret != "secret" {
+		t.Errorf("expected the file to take precedence, got %+v", opts)
+	}
+
+	opts = kafkaOpts{tlsKeyPasswordFile: filepath.Join(dir, "missing")}
+	if err := opts.resolveSecrets(); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestSecretWatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	global := filepath.Join(dir, "global")
+	module := filepath.Join(dir, "module")
+	writeFile(t, global, []byte("first"))
+	writeFile(t, module, []byte("first"))
+
+	sasl := func(path string) saslConfig { return saslConfig{Enabled: true, PasswordFile: path} }
+	cfg := &fileConfig{
+		Global:   clusterConfig{SASL: sasl(global)},
+		Clusters: []clusterConfig{{Name: "a", SASL: sasl(global)}, {Name: "b"}},
+		Modules:  map[string]clusterConfig{"m": {SASL: sasl(module)}},
+	}
+	w := newSecretWatch(cfg)
+	// The top level settings only describe a cluster without clusters.
+	if len(w.global) != 0 || len(w.clusters["a"]) != 1 || len(w.clusters["b"]) != 0 || len(w.modules["m"]) != 1 {
+		t.Fatalf("unexpected hashes: %+v", w)
+	}
+
+	writeFile(t, global, []byte("second"))
+	if changed, ok := w.clusters["a"].changed(); !ok || changed != global {
+		t.Errorf("expected %s to have changed, got %q", global, changed)
+	}
+	if _, changed := w.modules["m"].changed(); changed {
+		t.Error("expected no change for the module")
+	}
+	if _, changed := w.clusters["a"].rehash().changed(); changed {
+		t.Error("expected no change after hashing again")
+	}
+}
+
+func TestRefreshSecrets(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	writeFile(t, path, []byte("first"))
+	hashes := hashSecretFiles([]string{path})
+
+	reconnects := 0
+	reconnect := func(err error) func() error {
+		return func() error {
+			reconnects++
+			return err
+		}
+	}
+	if refreshSecrets("cluster a", hashes, reconnect(nil)); reconnects != 0 {
+		t.Error("expected no reconnection without changes")
+	}
+
+	writeFile(t, path, []byte("second"))
+	// A failed reconnection is retried at the next check.
+	failed := refreshSecrets("cluster a", hashes, reconnect(sarama.ErrOutOfBrokers))
+	if _, changed := failed.changed(); !changed || reconnects != 1 {
+		t.Errorf("expected the change to be kept after a failure, got %d reconnections", reconnects)
+	}
+	updated := refreshSecrets("cluster a", failed, reconnect(nil))
+	if _, changed := updated.changed(); changed || reconnects != 2 {
+		t.Errorf("expected the new content to be hashed, got %d reconnections", reconnects)
+	}
+}
+
+func TestLoadEncryptedX509KeyPair(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kafka_exporter"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+	writeFile(t, keyFile, pem.EncodeToMemory(block))
+
+	if _, err := loadX509KeyPair(certFile, keyFile, "secret"); err != nil {
+		t.Errorf("expected the key to be decrypted, got %v", err)
+	}
+	if _, err := loadX509KeyPair(certFile, keyFile, "wrong"); err == nil {
+		t.Error("expected an error with the wrong password")
+	}
+	if _, err := loadX509KeyPair(certFile, keyFile, ""); err == nil {
+		t.Error("expected an error without password")
+	}
+}
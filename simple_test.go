@@ -68,5 +68,5 @@ func runServer() {
 	opts.uriZookeeper = []string{"localhost:2181"}
 	opts.kafkaVersion = sarama.V1_0_0_0.String()
 	opts.metadataRefreshInterval = "30s"
-	setup("localhost:9304", "/metrics", ".*", ".*", false, opts, nil)
+	setup("localhost:9304", "/metrics", ".*", ".*", false, opts, nil, "", "")
 }
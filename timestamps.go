@@ -0,0 +1,229 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// timestampFetchPartitions is the most partitions fetched with a single
+	// Fetch request, to bound the size of the responses.
+	timestampFetchPartitions = 100
+
+	// timestampFetchBytes is the fetch size of each partition, only the
+	// first record at the target offset is needed.
+	timestampFetchBytes = 4 << 10
+)
+
+// collectTimestampMetrics exports the timestamp of the newest record and of
+// the oldest retained record of the partitions led by each broker. The
+// records are read with one Fetch request per leader and per batch of
+// partitions, the leaders are queried concurrently.
+func (e *Exporter) collectTimestampMetrics(ch chan<- prometheus.Metric, leaders map[*sarama.Broker]map[string][]int32, newest map[string]map[int32]int64, oldest map[string]map[int32]int64) {
+	var wg sync.WaitGroup
+	for broker, topics := range leaders {
+		newestTargets := make(map[string]map[int32]int64)
+		oldestTargets := make(map[string]map[int32]int64)
+		for topic, partitions := range topics {
+			for _, partition := range partitions {
+				newestOffset, ok := newest[topic][partition]
+				if !ok || newestOffset <= oldest[topic][partition] {
+					// Empty partition, or its offsets are unknown.
+					continue
+				}
+				if _, ok := newestTargets[topic]; !ok {
+					newestTargets[topic] = make(map[int32]int64, len(partitions))
+					oldestTargets[topic] = make(map[int32]int64, len(partitions))
+				}
+				newestTargets[topic][partition] = newestOffset - 1
+				oldestTargets[topic][partition] = oldest[topic][partition]
+			}
+		}
+
+		wg.Add(2)
+		go func(broker *sarama.Broker, targets map[string]map[int32]int64) {
+			defer wg.Done()
+			e.collectRecordTimestamps(ch, broker, targets, topicPartitionNewestTimestamp)
+		}(broker, newestTargets)
+		go func(broker *sarama.Broker, targets map[string]map[int32]int64) {
+			defer wg.Done()
+			e.collectRecordTimestamps(ch, broker, targets, topicPartitionOldestTimestamp)
+		}(broker, oldestTargets)
+	}
+	wg.Wait()
+}
+
+// collectRecordTimestamps exports the timestamp of the record at the target
+// offset of each partition, or of the next one if it was compacted.
+func (e *Exporter) collectRecordTimestamps(ch chan<- prometheus.Metric, broker *sarama.Broker, targets map[string]map[int32]int64, desc *prometheus.Desc) {
+	timestamps := e.fetchRecordTimestamps(broker, targets)
+	for topic, partitions := range timestamps {
+		for partition, timestamp := range partitions {
+			ch <- prometheus.MustNewConstMetric(
+				desc, prometheus.GaugeValue, float64(timestamp.UnixNano())/1e9, topic, strconv.FormatInt(int64(partition), 10),
+			)
+		}
+	}
+}
+
+// fetchRecordTimestamps returns the timestamp of the record at or after the
+// target offset of each partition led by the broker. The partitions are
+// fetched with a small fetch size, and only a record batch that is the first
+// of a response is returned whole when it exceeds it, so the partitions
+// whose first batch was truncated are fetched again on their own with the
+// default fetch size of the consumers.
+func (e *Exporter) fetchRecordTimestamps(broker *sarama.Broker, targets map[string]map[int32]int64) map[string]map[int32]time.Time {
+	timestamps := make(map[string]map[int32]time.Time)
+	var (
+		batch     = make(map[string]map[int32]int64)
+		batchSize int
+		truncated = make(map[string]map[int32]int64)
+	)
+	flush := func() {
+		for topic, partitions := range e.fetchTimestampBatch(broker, batch, timestampFetchBytes, timestamps) {
+			for partition, offset := range partitions {
+				addTarget(truncated, topic, partition, offset)
+			}
+		}
+		batch = make(map[string]map[int32]int64)
+		batchSize = 0
+	}
+	for topic, partitions := range targets {
+		for partition, offset := range partitions {
+			addTarget(batch, topic, partition, offset)
+			if batchSize++; batchSize == timestampFetchPartitions {
+				flush()
+			}
+		}
+	}
+	if batchSize > 0 {
+		flush()
+	}
+
+	for topic, partitions := range truncated {
+		for partition, offset := range partitions {
+			e.fetchTimestampBatch(broker, map[string]map[int32]int64{topic: {partition: offset}}, e.client.Config().Consumer.Fetch.Default, timestamps)
+		}
+	}
+	return timestamps
+}
+
+func addTarget(targets map[string]map[int32]int64, topic string, partition int32, offset int64) {
+	if _, ok := targets[topic]; !ok {
+		targets[topic] = make(map[int32]int64)
+	}
+	targets[topic][partition] = offset
+}
+
+// fetchTimestampBatch sends a single Fetch request for the target offsets,
+// reading up to fetchSize bytes of each partition, and adds the timestamps
+// found to timestamps. It returns the partitions whose records were
+// truncated. At most topic.timestamps.max-requests Fetch requests are sent
+// at once.
+func (e *Exporter) fetchTimestampBatch(broker *sarama.Broker, targets map[string]map[int32]int64, fetchSize int32, timestamps map[string]map[int32]time.Time) map[string]map[int32]int64 {
+	config := e.client.Config()
+	request := &sarama.FetchRequest{
+		MinBytes:    1,
+		MaxWaitTime: 0,
+		MaxBytes:    sarama.MaxResponseSize,
+	}
+	switch {
+	case config.Version.IsAtLeast(sarama.V0_11_0_0):
+		request.Version = 4
+	case config.Version.IsAtLeast(sarama.V0_10_1_0):
+		request.Version = 3
+	default:
+		request.Version = 2
+	}
+	for topic, partitions := range targets {
+		for partition, offset := range partitions {
+			request.AddBlock(topic, partition, offset, fetchSize)
+		}
+	}
+
+	if e.timestampFetches != nil {
+		e.timestampFetches <- struct{}{}
+		defer func() { <-e.timestampFetches }()
+	}
+	response, err := broker.Fetch(request)
+	e.stats.request("Fetch", broker, err)
+	if err != nil {
+		e.scrapeErrorf(phaseTimestamps, "Cannot fetch records from broker %d: %v", broker.ID(), err)
+		// The client reopens the connection the next time it looks the leader up.
+		_ = broker.Close()
+		return nil
+	}
+
+	truncated := make(map[string]map[int32]int64)
+	for topic, partitions := range targets {
+		for partition, offset := range partitions {
+			block := response.GetBlock(topic, partition)
+			switch {
+			case block == nil:
+				e.scrapeErrorf(phaseTimestamps, "Cannot fetch records of topic %s partition %d: %v", topic, partition, sarama.ErrIncompleteResponse)
+			case block.Err != sarama.ErrNoError:
+				e.scrapeErrorf(phaseTimestamps, "Cannot fetch records of topic %s partition %d: %v", topic, partition, block.Err)
+			default:
+				timestamp, ok := recordTimestamp(block, offset)
+				if ok && timestamp.Unix() <= 0 {
+					// The message format is older than 0.10, or the
+					// producer did not set the timestamp.
+					continue
+				}
+				if ok {
+					if _, found := timestamps[topic]; !found {
+						timestamps[topic] = make(map[int32]time.Time)
+					}
+					timestamps[topic][partition] = timestamp
+				} else if block.Partial {
+					addTarget(truncated, topic, partition, offset)
+				}
+			}
+		}
+	}
+	return truncated
+}
+
+// recordTimestamp returns the timestamp of the first record of the block at
+// or after offset.
+func recordTimestamp(block *sarama.FetchResponseBlock, offset int64) (time.Time, bool) {
+	for _, records := range block.RecordsSet {
+		if batch := records.RecordBatch; batch != nil {
+			for _, record := range batch.Records {
+				if batch.FirstOffset+record.OffsetDelta < offset {
+					continue
+				}
+				if batch.LogAppendTime {
+					return batch.MaxTimestamp, true
+				}
+				return batch.FirstTimestamp.Add(record.TimestampDelta), true
+			}
+		}
+		if records.MsgSet == nil {
+			continue
+		}
+		for _, wrapper := range records.MsgSet.Messages {
+			messages := wrapper.Messages()
+			// The offsets of compressed messages are relative since
+			// version 1, the wrapper has the offset of the last one.
+			var baseOffset int64
+			if wrapper.Msg.Set != nil && wrapper.Msg.Version >= 1 && len(messages) > 0 {
+				baseOffset = wrapper.Offset - messages[len(messages)-1].Offset
+			}
+			for _, message := range messages {
+				if baseOffset+message.Offset < offset {
+					continue
+				}
+				if wrapper.Msg.LogAppendTime {
+					return wrapper.Msg.Timestamp, true
+				}
+				return message.Msg.Timestamp, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFetchRecordTimestamps(t *testing.T) {
+	leader := sarama.NewMockBroker(t, 1)
+	defer leader.Close()
+
+	base := time.Unix(1634200000, 0)
+	fetch := &sarama.FetchResponse{Version: 4}
+	for offset := int64(0); offset < 5; offset++ {
+		fetch.AddRecordWithTimestamp("orders", 0, nil, sarama.StringEncoder("value"), offset, base.Add(time.Duration(offset)*time.Second))
+	}
+	fetch.AddError("payments", 0, sarama.ErrNotLeaderForPartition)
+	leader.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(leader.Addr(), leader.BrokerID()).
+			SetLeader("orders", 0, leader.BrokerID()).
+			SetLeader("payments", 0, leader.BrokerID()),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{leader.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	broker, err := client.Leader("orders", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Exporter{client: client}
+	timestamps := e.fetchRecordTimestamps(broker, map[string]map[int32]int64{
+		"orders":   {0: 3},
+		"payments": {0: 0},
+	})
+	if got := timestamps["orders"][0]; !got.Equal(base.Add(3 * time.Second)) {
+		t.Errorf("expected the timestamp of offset 3, got %v", got)
+	}
+	if _, ok := timestamps["payments"]; ok {
+		t.Errorf("expected no timestamp for a partition in error, got %v", timestamps["payments"])
+	}
+}
+
+func TestFetchRecordTimestampsMaxRequests(t *testing.T) {
+	leader := sarama.NewMockBroker(t, 1)
+	defer leader.Close()
+
+	fetch := &sarama.FetchResponse{Version: 4}
+	fetch.AddRecordWithTimestamp("orders", 0, nil, sarama.StringEncoder("value"), 0, time.Unix(1634200000, 0))
+	leader.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(leader.Addr(), leader.BrokerID()).
+			SetLeader("orders", 0, leader.BrokerID()),
+		"FetchRequest": sarama.NewMockWrapper(fetch),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_0_0_0
+	client, err := sarama.NewClient([]string{leader.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	broker, err := client.Leader("orders", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another Fetch request is in flight, with at most one at once.
+	e := &Exporter{client: client, timestampFetches: make(chan struct{}, 1)}
+	e.timestampFetches <- struct{}{}
+	done := make(chan map[string]map[int32]time.Time)
+	go func() {
+		done <- e.fetchRecordTimestamps(broker, map[string]map[int32]int64{"orders": {0: 0}})
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("expected the Fetch request to wait for the one in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-e.timestampFetches
+	if timestamps := <-done; len(timestamps["orders"]) != 1 {
+		t.Errorf("expected the timestamp of the record, got %v", timestamps)
+	}
+}
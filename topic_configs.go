@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// minInsyncReplicasConfig is always described, whether topic configs are
+// collected or not, to find out the partitions under min ISR.
+const minInsyncReplicasConfig = "min.insync.replicas"
+
+// topicConfigs returns the configuration of the given topics. The configs are
+// described again at most once per metadata refresh interval, in between the
+// cached configs are returned. Brokers older than 0.11 do not support
+// DescribeConfigs and close the connection, so no config is returned for
+// them.
+func (e *Exporter) topicConfigs(topics []string) map[string]map[string]string {
+	if !e.client.Config().Version.IsAtLeast(sarama.V0_11_0_0) {
+		return nil
+	}
+	e.topicConfigMu.Lock()
+	defer e.topicConfigMu.Unlock()
+
+	now := time.Now()
+	if now.Before(e.nextTopicConfigRefresh) {
+		return e.topicConfigCache
+	}
+	// Failures are retried at the next refresh.
+	e.nextTopicConfigRefresh = now.Add(e.metadataRefreshInterval)
+
+	names := []string{minInsyncReplicasConfig}
+	if e.topicConfigEnabled {
+		for _, name := range e.topicConfigNames {
+			if name != minInsyncReplicasConfig {
+				names = append(names, name)
+			}
+		}
+	}
+	configs, err := e.describeTopicConfigs(topics, names)
+	if err != nil {
+		e.scrapeErrorf(phaseMetadata, "Cannot describe topic configs, using cached data: %v", err)
+		return e.topicConfigCache
+	}
+	e.topicConfigCache = configs
+	return configs
+}
+
+// minInsyncReplicas returns the effective min.insync.replicas of a topic.
+func minInsyncReplicas(configs map[string]map[string]string, topic string) (int, bool) {
+	value, ok := configs[topic][minInsyncReplicasConfig]
+	if !ok {
+		return 0, false
+	}
+	minISR, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return minISR, true
+}
+
+// describeTopicConfigs sends a single DescribeConfigs request for the given
+// topics and config names to the controller. The values are the effective
+// ones, which come from the broker defaults unless the topic overrides them.
+func (e *Exporter) describeTopicConfigs(topics []string, names []string) (map[string]map[string]string, error) {
+	request := &sarama.DescribeConfigsRequest{}
+	if e.client.Config().Version.IsAtLeast(sarama.V1_1_0_0) {
+		request.Version = 1
+	}
+	if e.client.Config().Version.IsAtLeast(sarama.V2_0_0_0) {
+		request.Version = 2
+	}
+	for _, topic := range topics {
+		request.Resources = append(request.Resources, &sarama.ConfigResource{
+			Type:        sarama.TopicResource,
+			Name:        topic,
+			ConfigNames: names,
+		})
+	}
+
+	broker, err := e.client.Controller()
+	if err != nil {
+		return nil, err
+	}
+	response, err := broker.DescribeConfigs(request)
+	e.stats.request("DescribeConfigs", broker, err)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]map[string]string, len(response.Resources))
+	for _, resource := range response.Resources {
+		if resource.ErrorCode != int16(sarama.ErrNoError) {
+			e.scrapeErrorf(phaseMetadata, "Cannot describe configs of topic %s: %v", resource.Name,
+				fmt.Errorf("%v: %s", sarama.KError(resource.ErrorCode), resource.ErrorMsg))
+			continue
+		}
+		values := make(map[string]string, len(resource.Configs))
+		for _, entry := range resource.Configs {
+			if entry.Sensitive {
+				continue
+			}
+			values[entry.Name] = entry.Value
+		}
+		configs[resource.Name] = values
+	}
+	return configs, nil
+}
+
+// collectTopicConfigMetrics exports the numeric configs of the topics as
+// gauges and the other ones as info metrics.
+func (e *Exporter) collectTopicConfigMetrics(ch chan<- prometheus.Metric, configs map[string]map[string]string) {
+	for topic, values := range configs {
+		for _, name := range e.topicConfigNames {
+			value, ok := values[name]
+			if !ok {
+				continue
+			}
+			if number, err := strconv.ParseFloat(value, 64); err == nil {
+				ch <- prometheus.MustNewConstMetric(
+					topicConfig, prometheus.GaugeValue, number, topic, name,
+				)
+			} else {
+				ch <- prometheus.MustNewConstMetric(
+					topicConfigInfo, prometheus.GaugeValue, 1, topic, name, value,
+				)
+			}
+		}
+	}
+}
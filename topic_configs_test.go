@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestTopicConfigsRequireDescribeConfigs(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_2_0
+	client, err := sarama.NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	e := &Exporter{client: client}
+	if configs := e.topicConfigs([]string{"orders"}); configs != nil {
+		t.Errorf("expected no configs from a 0.10 broker, got %v", configs)
+	}
+	for _, r := range broker.History() {
+		if _, ok := r.Request.(*sarama.DescribeConfigsRequest); ok {
+			t.Error("expected no DescribeConfigs request to a 0.10 broker")
+		}
+	}
+	if errors := e.stats.errors[phaseMetadata]; errors != 0 {
+		t.Errorf("expected no scrape error, got %v", errors)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/golang/glog"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// webConfig is the content of the file given with --web.config.file, in the
+// format of the Prometheus exporters.
+type webConfig struct {
+	TLSConfig      webTLSConfig      `yaml:"tls_server_config"`
+	HTTPConfig     webHTTPConfig     `yaml:"http_server_config"`
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+}
+
+type webTLSConfig struct {
+	CertFile                 string   `yaml:"cert_file"`
+	KeyFile                  string   `yaml:"key_file"`
+	ClientAuth               string   `yaml:"client_auth_type"`
+	ClientCAs                string   `yaml:"client_ca_file"`
+	MinVersion               string   `yaml:"min_version"`
+	MaxVersion               string   `yaml:"max_version"`
+	CipherSuites             []string `yaml:"cipher_suites"`
+	CurvePreferences         []string `yaml:"curve_preferences"`
+	PreferServerCipherSuites bool     `yaml:"prefer_server_cipher_suites"`
+}
+
+type webHTTPConfig struct {
+	HTTP2   bool              `yaml:"http2"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+var webClientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var webTLSVersions = map[string]uint16{
+	"":      0,
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var webCurves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// webHeaders are the response headers the web config file can set, the
+// others being set by the handlers.
+var webHeaders = map[string]bool{
+	"Strict-Transport-Security": true,
+	"X-Content-Type-Options":    true,
+	"X-Frame-Options":           true,
+	"X-XSS-Protection":          true,
+	"Content-Security-Policy":   true,
+}
+
+// webCipherSuite returns the ID of the cipher suite with the given name, as
+// named by crypto/tls.
+func webCipherSuite(name string) (uint16, bool) {
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+func loadWebConfig(path string) (*webConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &webConfig{
+		TLSConfig:  webTLSConfig{PreferServerCipherSuites: true},
+		HTTPConfig: webHTTPConfig{HTTP2: true},
+	}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("error loading web config file %s: %v", path, err)
+	}
+	for name := range cfg.HTTPConfig.Headers {
+		if !webHeaders[http.CanonicalHeaderKey(name)] {
+			return nil, fmt.Errorf("error loading web config file %s: header %s cannot be set", path, name)
+		}
+	}
+	return cfg, nil
+}
+
+// enabled tells whether TLS is configured, which the other settings alone do
+// not do.
+func (c webTLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || c.ClientAuth != "" || c.ClientCAs != ""
+}
+
+// tlsConfig loads the certificates and returns the TLS configuration of the
+// web server.
+func (c webTLSConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("cert_file and key_file are required for TLS")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	clientAuth, ok := webClientAuthTypes[c.ClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("invalid client_auth_type %q", c.ClientAuth)
+	}
+	config.ClientAuth = clientAuth
+	if c.ClientCAs != "" {
+		ca, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in client_ca_file %s", c.ClientCAs)
+		}
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_ca_file is required with client_auth_type %s", c.ClientAuth)
+	}
+
+	if config.MinVersion, ok = webTLSVersions[c.MinVersion]; !ok {
+		return nil, fmt.Errorf("invalid min_version %q", c.MinVersion)
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if config.MaxVersion, ok = webTLSVersions[c.MaxVersion]; !ok {
+		return nil, fmt.Errorf("invalid max_version %q", c.MaxVersion)
+	}
+
+	for _, name := range c.CipherSuites {
+		suite, ok := webCipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, suite)
+	}
+	for _, name := range c.CurvePreferences {
+		curve, ok := webCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid curve %q", name)
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+	config.PreferServerCipherSuites = c.PreferServerCipherSuites
+	return config, nil
+}
+
+// basicAuthHandler requires the basic auth users of the web config file, and
+// sets the headers of its http_server_config, the file being read again on
+// every request.
+type basicAuthHandler struct {
+	path    string
+	handler http.Handler
+
+	// The successful authentications, bcrypt being slow on purpose.
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]bool
+}
+
+// basicAuthCacheSize bounds the number of cached authentications.
+const basicAuthCacheSize = 100
+
+func (h *basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg, err := loadWebConfig(h.path)
+	if err != nil {
+		glog.Errorf("Cannot reload web config: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	for name, value := range cfg.HTTPConfig.Headers {
+		w.Header().Set(name, value)
+	}
+	if len(cfg.BasicAuthUsers) > 0 {
+		user, password, ok := r.BasicAuth()
+		if !ok || !h.authenticate(cfg.BasicAuthUsers, user, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Kafka Exporter"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+func (h *basicAuthHandler) authenticate(users map[string]string, user string, password string) bool {
+	hash, ok := users[user]
+	if !ok {
+		// Compare anyway so that unknown users take as long as known ones.
+		hash = "$2a$10$DxYOO54PDQIYG4lQRPouvu97MotBAbULVTMCjvH.6Sw9gu1XtcAye"
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + hash + "\x00" + password))
+
+	h.mu.Lock()
+	cached := h.cache[key]
+	h.mu.Unlock()
+	if cached {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !ok {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cache == nil || len(h.cache) >= basicAuthCacheSize {
+		h.cache = make(map[[sha256.Size]byte]bool)
+	}
+	h.cache[key] = true
+	return true
+}
+
+// listenAndServe serves handler on the address, with the TLS, HTTP and basic
+// auth settings of the web config file when one is given. The file is read
+// again on every TLS handshake and request, so that the certificates and
+// users are reloaded without restarting the exporter. Enabling TLS or HTTP/2
+// requires a restart.
+func listenAndServe(address string, webConfigFile string, handler http.Handler) error {
+	if webConfigFile == "" {
+		return http.ListenAndServe(address, handler)
+	}
+	cfg, err := loadWebConfig(webConfigFile)
+	if err != nil {
+		return err
+	}
+	tlsEnabled := cfg.TLSConfig.enabled()
+	if tlsEnabled {
+		if _, err := cfg.TLSConfig.tlsConfig(); err != nil {
+			return fmt.Errorf("error loading web config file %s: %v", webConfigFile, err)
+		}
+	}
+	server := &http.Server{Handler: &basicAuthHandler{path: webConfigFile, handler: handler}}
+	http2 := cfg.HTTPConfig.HTTP2
+	if !http2 {
+		// A non-nil empty map disables HTTP/2.
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if !tlsEnabled {
+		glog.Infoln("TLS is disabled")
+		return server.Serve(listener)
+	}
+
+	glog.Infoln("TLS is enabled")
+	return server.Serve(tls.NewListener(listener, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := loadWebConfig(webConfigFile)
+			if err != nil {
+				glog.Errorf("Cannot reload web config: %v", err)
+				return nil, err
+			}
+			if !cfg.TLSConfig.enabled() {
+				return nil, errors.New("TLS cannot be disabled without restarting the exporter")
+			}
+			config, err := cfg.TLSConfig.tlsConfig()
+			if err != nil {
+				return nil, err
+			}
+			config.NextProtos = []string{"http/1.1"}
+			if http2 {
+				config.NextProtos = []string{"h2", "http/1.1"}
+			}
+			return config, nil
+		},
+	}))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuthHandler(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "web.yml")
+	if err := ioutil.WriteFile(path, []byte(fmt.Sprintf("basic_auth_users:\n  prometheus: %s\n", hash)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	handler := &basicAuthHandler{path: path, handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+
+	for _, tc := range []struct {
+		user, password string
+		expected       int
+	}{
+		{user: "prometheus", password: "secret", expected: http.StatusOK},
+		// Served from the cache.
+		{user: "prometheus", password: "secret", expected: http.StatusOK},
+		{user: "prometheus", password: "wrong", expected: http.StatusUnauthorized},
+		{user: "unknown", password: "secret", expected: http.StatusUnauthorized},
+		{expected: http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.user != "" {
+			r.SetBasicAuth(tc.user, tc.password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tc.expected {
+			t.Errorf("user %q password %q: expected status %d, got %d", tc.user, tc.password, tc.expected, w.Code)
+		}
+	}
+}
+
+// writeServerCertificate writes a self-signed certificate and its key to dir
+// and returns their paths.
+func writeServerCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	return certFile, keyFile
+}
+
+func TestLoadWebConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeServerCertificate(t, dir)
+	path := filepath.Join(dir, "web.yml")
+	writeFile(t, path, []byte(fmt.Sprintf(`
+tls_server_config:
+  cert_file: %s
+  key_file: %s
+  cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384]
+  curve_preferences: [X25519, CurveP256]
+http_server_config:
+  http2: false
+  headers:
+    X-Frame-Options: deny
+`, certFile, keyFile)))
+
+	cfg, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTPConfig.HTTP2 || cfg.HTTPConfig.Headers["X-Frame-Options"] != "deny" {
+		t.Errorf("unexpected HTTP config: %+v", cfg.HTTPConfig)
+	}
+	config, err := cfg.TLSConfig.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if fmt.Sprint(config.CipherSuites) != fmt.Sprint(suites) {
+		t.Errorf("expected cipher suites %v, got %v", suites, config.CipherSuites)
+	}
+	curves := []tls.CurveID{tls.X25519, tls.CurveP256}
+	if fmt.Sprint(config.CurvePreferences) != fmt.Sprint(curves) {
+		t.Errorf("expected curves %v, got %v", curves, config.CurvePreferences)
+	}
+	// Defaults of the Prometheus exporters.
+	if !config.PreferServerCipherSuites {
+		t.Error("expected the server cipher suites to be preferred by default")
+	}
+
+	handler := &basicAuthHandler{path: path, handler: http.NotFoundHandler()}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if header := w.Header().Get("X-Frame-Options"); header != "deny" {
+		t.Errorf("expected the header of the config file, got %q", header)
+	}
+
+	writeFile(t, path, []byte("http_server_config:\n  headers:\n    Set-Cookie: session=1\n"))
+	if _, err := loadWebConfig(path); err == nil {
+		t.Error("expected an error for a header that cannot be set")
+	}
+}
+
+func TestWebTLSConfigErrors(t *testing.T) {
+	certFile, keyFile := writeServerCertificate(t, t.TempDir())
+	for name, config := range map[string]webTLSConfig{
+		"missing key":       {CertFile: "server.crt"},
+		"missing cert file": {CertFile: "missing.crt", KeyFile: "missing.key"},
+		"bad cipher suite":  {CertFile: certFile, KeyFile: keyFile, CipherSuites: []string{"TLS_NULL"}},
+		"bad curve":         {CertFile: certFile, KeyFile: keyFile, CurvePreferences: []string{"P-256"}},
+	} {
+		if _, err := config.tlsConfig(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
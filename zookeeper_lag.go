@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/krallistic/kazoo-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// zooKeeperGroup is the part of kazoo.Consumergroup the lag, members and
+// owners of a group are read from.
+type zooKeeperGroup interface {
+	Instances() (kazoo.ConsumergroupInstanceList, error)
+	FetchAllOffsets() (map[string]map[int32]int64, error)
+	PartitionOwner(topic string, partition int32) (*kazoo.ConsumergroupInstance, error)
+}
+
+// collectZooKeeperLagMetrics exports the lag, members and partition owners of
+// the consumer groups committing their offsets to ZooKeeper, for the topics
+// whose newest offsets are given. The groups are listed once per scrape.
+func (e *Exporter) collectZooKeeperLagMetrics(ch chan<- prometheus.Metric, offsets map[string]map[int32]int64) {
+	list, err := e.zookeeperClient.Consumergroups()
+	if err != nil {
+		e.scrapeErrorf(phaseZooKeeper, "Cannot get consumer group %v", err)
+		return
+	}
+	groups := make(map[string]zooKeeperGroup, len(list))
+	for _, group := range list {
+		groups[group.Name] = group
+	}
+	e.collectZooKeeperGroups(ch, groups, offsets)
+}
+
+// collectZooKeeperGroups exports the metrics of the groups matching the group
+// filter, up to topic.workers groups being fetched at once.
+func (e *Exporter) collectZooKeeperGroups(ch chan<- prometheus.Metric, groups map[string]zooKeeperGroup, offsets map[string]map[int32]int64) {
+	var (
+		wg           sync.WaitGroup
+		groupChannel = make(chan string)
+	)
+	workers := e.topicWorkers
+	if len(groups) < workers {
+		workers = len(groups)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range groupChannel {
+				e.collectZooKeeperGroup(ch, name, groups[name], offsets)
+			}
+		}()
+	}
+	for name := range groups {
+		if e.groupFilter.MatchString(name) {
+			groupChannel <- name
+		}
+	}
+	close(groupChannel)
+	wg.Wait()
+}
+
+func (e *Exporter) collectZooKeeperGroup(ch chan<- prometheus.Metric, name string, group zooKeeperGroup, offsets map[string]map[int32]int64) {
+	instances, err := group.Instances()
+	if err != nil {
+		e.scrapeErrorf(phaseZooKeeper, "Cannot get members of group %s from ZooKeeper: %v", name, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			consumergroupZookeeperMembers, prometheus.GaugeValue, float64(len(instances)), name,
+		)
+	}
+
+	groupOffsets, err := group.FetchAllOffsets()
+	if err != nil {
+		e.scrapeErrorf(phaseZooKeeper, "Cannot get offsets of group %s from ZooKeeper: %v", name, err)
+		return
+	}
+	for topic, partitions := range groupOffsets {
+		for partition, offset := range partitions {
+			currentOffset, ok := offsets[topic][partition]
+			if !ok || offset <= 0 {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(
+				consumergroupLagZookeeper, prometheus.GaugeValue, float64(currentOffset-offset), name, topic, strconv.FormatInt(int64(partition), 10),
+			)
+
+			owner, err := group.PartitionOwner(topic, partition)
+			if err != nil {
+				e.scrapeErrorf(phaseZooKeeper, "Cannot get owner of topic %s partition %d for group %s from ZooKeeper: %v", topic, partition, name, err)
+				continue
+			}
+			if owner != nil {
+				ch <- prometheus.MustNewConstMetric(
+					consumergroupZookeeperOwner, prometheus.GaugeValue, 1, name, topic, strconv.FormatInt(int64(partition), 10), owner.ID,
+				)
+			}
+		}
+	}
+}
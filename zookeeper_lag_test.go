@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krallistic/kazoo-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeZooKeeperGroup serves the members, offsets and owners of a group, and
+// records how many groups are being fetched at once.
+type fakeZooKeeperGroup struct {
+	members []string
+	offsets map[string]map[int32]int64
+	owners  map[int32]string
+	fetches *concurrencyCounter
+}
+
+type concurrencyCounter struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrencyCounter) enter() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+}
+
+func (c *concurrencyCounter) leave() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current--
+}
+
+func (g *fakeZooKeeperGroup) Instances() (kazoo.ConsumergroupInstanceList, error) {
+	if g.fetches != nil {
+		g.fetches.enter()
+		defer g.fetches.leave()
+		time.Sleep(10 * time.Millisecond)
+	}
+	var instances kazoo.ConsumergroupInstanceList
+	for _, id := range g.members {
+		instances = append(instances, &kazoo.ConsumergroupInstance{ID: id})
+	}
+	return instances, nil
+}
+
+func (g *fakeZooKeeperGroup) FetchAllOffsets() (map[string]map[int32]int64, error) {
+	return g.offsets, nil
+}
+
+func (g *fakeZooKeeperGroup) PartitionOwner(topic string, partition int32) (*kazoo.ConsumergroupInstance, error) {
+	owner, ok := g.owners[partition]
+	if !ok {
+		return nil, errors.New("no owner node")
+	}
+	if owner == "" {
+		return nil, nil
+	}
+	return &kazoo.ConsumergroupInstance{ID: owner}, nil
+}
+
+func TestCollectZooKeeperGroups(t *testing.T) {
+	consumergroupLagZookeeper = testDesc("lag", "consumergroup", "topic", "partition")
+	consumergroupZookeeperMembers = testDesc("members", "consumergroup")
+	consumergroupZookeeperOwner = testDesc("owner", "consumergroup", "topic", "partition", "owner")
+	descs := map[*prometheus.Desc]string{
+		consumergroupLagZookeeper:     "lag",
+		consumergroupZookeeperMembers: "members",
+		consumergroupZookeeperOwner:   "owner",
+	}
+
+	groups := map[string]zooKeeperGroup{
+		"billing": &fakeZooKeeperGroup{
+			members: []string{"billing-1", "billing-2"},
+			offsets: map[string]map[int32]int64{
+				// Partition 2 has no committed offset, and the deleted topic
+				// has no newest offset.
+				"orders":  {0: 90, 1: 150, 2: 0},
+				"deleted": {0: 10},
+			},
+			// Partition 1 is not owned by any member.
+			owners: map[int32]string{0: "billing-1", 1: ""},
+		},
+		"console-consumer-1": &fakeZooKeeperGroup{members: []string{"console"}},
+	}
+	offsets := map[string]map[int32]int64{"orders": {0: 100, 1: 200, 2: 300}}
+
+	e := &Exporter{groupFilter: regexp.MustCompile("^billing$"), topicWorkers: 10}
+	metrics := collectTestMetrics(t, descs, func(ch chan<- prometheus.Metric) {
+		e.collectZooKeeperGroups(ch, groups, offsets)
+	})
+
+	expected := map[string]float64{
+		`members{consumergroup="billing"}`:                                              2,
+		`lag{consumergroup="billing",partition="0",topic="orders"}`:                     10,
+		`lag{consumergroup="billing",partition="1",topic="orders"}`:                     50,
+		`owner{consumergroup="billing",owner="billing-1",partition="0",topic="orders"}`: 1,
+	}
+	if len(metrics) != len(expected) {
+		t.Errorf("expected %d metrics, got %v", len(expected), metrics)
+	}
+	for key, value := range expected {
+		if got, ok := metrics[key]; !ok || got != value {
+			t.Errorf("%s: expected %v, got %v (%t)", key, value, got, ok)
+		}
+	}
+}
+
+func TestCollectZooKeeperGroupsWorkers(t *testing.T) {
+	consumergroupZookeeperMembers = testDesc("members", "consumergroup")
+
+	fetches := &concurrencyCounter{}
+	groups := make(map[string]zooKeeperGroup)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		groups[name] = &fakeZooKeeperGroup{members: []string{name}, fetches: fetches}
+	}
+
+	e := &Exporter{groupFilter: regexp.MustCompile(".*"), topicWorkers: 3}
+	metrics := collectTestMetrics(t, nil, func(ch chan<- prometheus.Metric) {
+		e.collectZooKeeperGroups(ch, groups, nil)
+	})
+	if len(metrics) != len(groups) {
+		t.Errorf("expected the members of %d groups, got %v", len(groups), metrics)
+	}
+	if fetches.max > e.topicWorkers {
+		t.Errorf("expected at most %d groups fetched at once, got %d", e.topicWorkers, fetches.max)
+	}
+}